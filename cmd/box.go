@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"github.com/webishdev/fritze-mqtt/internal"
+	"github.com/webishdev/fritze-mqtt/log"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type box struct {
+	config      internal.BoxConfig
+	topicPrefix string
+	client      fritzbox.FritzClient
+	tr064Client fritzbox.TR064Client
+	reloginChan chan byte
+	commandChan chan internal.DeviceCommand
+	publishChan chan internal.Message
+}
+
+func newBox(config internal.BoxConfig) (*box, error) {
+	client, err := fritzbox.NewFritzClient(config.BaseURL, config.TLSOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	tr064Client, err := fritzbox.NewTR064Client(config.BaseURL, config.TLSOptions(), config.Username, config.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	return &box{
+		config:      config,
+		topicPrefix: config.TopicPrefix(topicPrefix),
+		client:      client,
+		tr064Client: tr064Client,
+		reloginChan: make(chan byte, 1),
+		commandChan: make(chan internal.DeviceCommand, 10),
+	}, nil
+}
+
+func (b *box) commands() []internal.Command {
+	var commands []internal.Command
+	if layout == internal.LayoutHomie {
+		commands = internal.HomieCommands(b.homieRoot(), b.commandChan)
+	} else {
+		commands = internal.DeviceCommands(b.topicPrefix, b.commandChan)
+	}
+	commands = append(commands, internal.BridgeCommands(b.topicPrefix, b.commandChan, b.publishChan)...)
+	if wlan {
+		commands = append(commands, internal.WLANCommands(b.tr064Client, b.publishChan, b.topicPrefix)...)
+	}
+	if wakeOnLAN {
+		commands = append(commands, internal.WakeOnLANCommands(b.tr064Client, b.publishChan, b.topicPrefix)...)
+	}
+	if hostFilter {
+		commands = append(commands, internal.HostFilterCommands(b.tr064Client, b.publishChan, b.topicPrefix)...)
+	}
+	if dial {
+		commands = append(commands, internal.DialCommands(b.tr064Client, b.topicPrefix, dialPhone)...)
+	}
+	if tam {
+		commands = append(commands, internal.TAMCommands(b.tr064Client, b.publishChan, b.topicPrefix)...)
+	}
+	return commands
+}
+
+func (b *box) start(wg *sync.WaitGroup) {
+	b.run(wg, "controller", func(teardown chan byte) error {
+		return internal.StartController(teardown, b.reloginChan, b.commandChan, b.client, b.config.Username, b.config.Password, b.publishChan, internal.ControllerOptions{
+			TopicPrefix:       b.topicPrefix,
+			HomeAssistant:     homeAssistant,
+			DiscoveryPrefix:   homeAssistantPrefix,
+			Layout:            layout,
+			HomieRoot:         b.homieRoot(),
+			HomieVersion:      homieVersion,
+			HomieName:         b.homieName(),
+			StatusTopic:       internal.StatusTopic(topicPrefix),
+			Template:          deviceTemplate,
+			RetainedStateFile: b.stateFile(retainedState),
+		})
+	})
+
+	if callMonitor {
+		b.run(wg, "call monitor", func(teardown chan byte) error {
+			callMonitorAddress, errAddress := fritzbox.CallMonitorAddress(b.config.BaseURL)
+			if errAddress != nil {
+				return errAddress
+			}
+			return internal.StartCallMonitor(teardown, callMonitorAddress, b.publishChan, b.topicPrefix)
+		})
+	}
+
+	if presence {
+		b.run(wg, "presence", func(teardown chan byte) error {
+			return internal.StartPresence(teardown, b.tr064Client, b.publishChan, b.topicPrefix, presenceInterval, presenceHomeGrace, presenceAwayGrace, presenceMACs)
+		})
+	}
+
+	if wan {
+		b.run(wg, "WAN", func(teardown chan byte) error {
+			return internal.StartWAN(teardown, b.tr064Client, b.publishChan, b.topicPrefix, wanInterval)
+		})
+	}
+
+	if wlan {
+		b.run(wg, "WLAN", func(teardown chan byte) error {
+			return internal.StartWLAN(teardown, b.tr064Client, b.publishChan, b.topicPrefix, wlanInterval)
+		})
+	}
+
+	if hostFilter {
+		b.run(wg, "host filter", func(teardown chan byte) error {
+			return internal.StartHostFilter(teardown, b.tr064Client, b.publishChan, b.topicPrefix, hostFilterInterval, hostFilterHosts)
+		})
+	}
+
+	if tam {
+		b.run(wg, "answering machines", func(teardown chan byte) error {
+			return internal.StartTAM(teardown, b.tr064Client, b.publishChan, b.topicPrefix, tamInterval)
+		})
+	}
+
+	if calls {
+		b.run(wg, "call list", func(teardown chan byte) error {
+			return internal.StartCalls(teardown, b.tr064Client, b.publishChan, b.topicPrefix, callsInterval, callsDays)
+		})
+	}
+
+	if system {
+		b.run(wg, "system", func(teardown chan byte) error {
+			return internal.StartSystem(teardown, b.tr064Client, b.publishChan, b.topicPrefix, systemInterval, b.reloginChan)
+		})
+	}
+
+	if eventLog {
+		b.run(wg, "event log", func(teardown chan byte) error {
+			return internal.StartEventLog(teardown, b.tr064Client, b.publishChan, b.topicPrefix, eventLogInterval, b.stateFile(eventLogState))
+		})
+	}
+}
+
+// Every subsystem of a box runs on its own, a failing or crashing one must not take down other boxes
+func (b *box) run(wg *sync.WaitGroup, subsystem string, start func(teardown chan byte) error) {
+	teardown := newTeardown()
+	name := subsystem
+	if b.config.Name != "" {
+		name = fmt.Sprintf("%s of %s", subsystem, b.config.Name)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				log.Error("The %s crashed: %v", name, r)
+			}
+		}()
+		err := start(teardown)
+		if err != nil {
+			log.Error("The %s stopped: %s", name, err)
+		}
+	}()
+}
+
+func (b *box) stateFile(file string) string {
+	if file == "" || b.config.Name == "" {
+		return file
+	}
+	ext := filepath.Ext(file)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(file, ext), b.config.Name, ext)
+}
+
+// Every box is a Homie device of its own, named after the box
+func (b *box) homieRoot() string {
+	return internal.HomieRoot(homiePrefix, homieVersion, b.homieDeviceID())
+}
+
+func (b *box) homieDeviceID() string {
+	if b.config.Name != "" {
+		return internal.HomieID(b.config.Name)
+	}
+	return "fritzbox"
+}
+
+func (b *box) homieName() string {
+	if b.config.Name != "" {
+		return b.config.Name
+	}
+	return "FRITZ!Box"
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/internal"
+)
+
+type connection struct {
+	options     internal.MQTTOptions
+	publishChan chan internal.Message
+	commands    []internal.Command
+	teardown    chan byte
+	done        chan struct{}
+	err         error
+}
+
+func newConnection(options internal.MQTTOptions) *connection {
+	return &connection{
+		options:     options,
+		publishChan: make(chan internal.Message, 100),
+		teardown:    make(chan byte, 1),
+		done:        make(chan struct{}),
+	}
+}
+
+func (c *connection) start(failed chan *connection) {
+	go func() {
+		c.err = internal.StartMQTT(c.teardown, c.options, c.publishChan, c.commands)
+		close(c.done)
+		if c.err != nil {
+			failed <- c
+		}
+	}()
+}
+
+// A connection has only one Last Will, so with the Homie layout every box is a device with a connection of its own
+func (b *box) mqttOptions(options internal.MQTTOptions) internal.MQTTOptions {
+	if layout != internal.LayoutHomie {
+		return options
+	}
+	options.ClientID = fmt.Sprintf("%s-%s", options.ClientID, b.homieDeviceID())
+	options.StatusTopic = ""
+	options.HomieStateTopic = internal.HomieStateTopic(b.homieRoot())
+	options.OutboxFile = b.stateFile(options.OutboxFile)
+	return options
+}
+
+// Keeps the bridge status and its Last Will when the connections of the boxes carry the Homie wills
+func statusConnection(options internal.MQTTOptions) *connection {
+	options.OutboxFile = ""
+	return newConnection(options)
+}
@@ -8,11 +8,14 @@ import (
 	"github.com/webishdev/fritze-mqtt/fritzbox"
 	"github.com/webishdev/fritze-mqtt/internal"
 	"github.com/webishdev/fritze-mqtt/log"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 )
 
 var Version = "development"
@@ -20,16 +23,84 @@ var GitHash = "none"
 
 var showVersion = false
 var listOnly = false
+var listServices = false
+var listBoxes = false
+var discover = false
+var configFile string
+var boxName string
+var wakeTarget string
+var blockTarget string
+var unblockTarget string
+var dialNumber string
+var dialPhone string
+var hangup bool
+var exportCalls string
 var baseUrl string
 var username string
 var password string
+var insecure bool
+var caFile string
+var fingerprint string
+var fingerprintFile string
 var brokerHost string
 var brokerPort int
 var mqttTopic string
+var brokerURL string
+var mqttHeaders []string
+var mqttClientID string
+var mqttVersion int
+var mqttOutboxSize int
+var retainState bool
+var retainEvents bool
+var retainDiscovery bool
+var retainedState string
+var mqttOutboxFile string
+var mqttTopicAliases uint16
+var mqttUsername string
+var mqttPassword string
+var mqttTLS bool
+var mqttInsecure bool
+var mqttCAFile string
+var mqttCertFile string
+var mqttKeyFile string
+var topicPrefix string
+var layout string
+var homieVersion int
+var homiePrefix string
+var topicTemplate string
+var payloadMode string
+var payloadTemplate string
+var deviceTemplate *internal.DeviceTemplate
+var homeAssistant bool
+var homeAssistantPrefix string
+var callMonitor bool
+var presence bool
+var presenceInterval time.Duration
+var presenceHomeGrace time.Duration
+var presenceAwayGrace time.Duration
+var presenceMACs []string
+var wan bool
+var wanInterval time.Duration
+var wlan bool
+var wlanInterval time.Duration
+var wakeOnLAN bool
+var hostFilter bool
+var hostFilterInterval time.Duration
+var hostFilterHosts []string
+var dial bool
+var tam bool
+var tamInterval time.Duration
+var calls bool
+var callsInterval time.Duration
+var callsDays int
+var system bool
+var systemInterval time.Duration
+var eventLog bool
+var eventLogInterval time.Duration
+var eventLogState string
 
 var sigs chan os.Signal
-var controllerTeardown chan byte
-var mqttTeardown chan byte
+var teardowns []chan byte
 
 var versionMessage = fmt.Sprintf("Fritze MQTT (Version: %s, Hash: %s)", Version, GitHash)
 
@@ -56,6 +127,201 @@ func do() error {
 
 	fmt.Println()
 
+	if listBoxes {
+		return internal.ListBoxes(5 * time.Second)
+	}
+
+	if discover {
+		discovered, errDiscover := fritzbox.Discover(5 * time.Second)
+		if errDiscover != nil {
+			return errDiscover
+		}
+		if len(discovered) == 0 {
+			return fmt.Errorf("no device found on the network")
+		}
+		if len(discovered) > 1 {
+			log.Warn("Found %d devices, using the first one", len(discovered))
+		}
+		baseUrl = discovered[0].BaseURL
+		log.Info("Discovered %s (%s) at %s", discovered[0].Name, discovered[0].Model, baseUrl)
+	}
+
+	if layout != internal.LayoutDefault && layout != internal.LayoutHomie {
+		return fmt.Errorf("unknown layout %s", layout)
+	}
+
+	if layout == internal.LayoutHomie && homieVersion != 4 && homieVersion != 5 {
+		return fmt.Errorf("unsupported Homie version %d", homieVersion)
+	}
+
+	if layout == internal.LayoutHomie && homeAssistant {
+		return fmt.Errorf("Home Assistant discovery requires the default layout")
+	}
+
+	if layout == internal.LayoutHomie && (topicTemplate != "" || payloadMode != internal.PayloadRaw || payloadTemplate != "") {
+		return fmt.Errorf("topic and payload templates require the default layout")
+	}
+
+	if homeAssistant && payloadMode != internal.PayloadRaw {
+		return fmt.Errorf("Home Assistant discovery requires the %s payload mode", internal.PayloadRaw)
+	}
+
+	var errTemplate error
+	deviceTemplate, errTemplate = internal.NewDeviceTemplate(topicTemplate, payloadMode, payloadTemplate)
+	if errTemplate != nil {
+		return errTemplate
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	cliBox, err := config.Box(boxName)
+	if err != nil {
+		return err
+	}
+
+	if listServices || wakeTarget != "" || blockTarget != "" || unblockTarget != "" || dialNumber != "" || hangup || exportCalls != "" || listOnly {
+		return doOnce(cliBox)
+	}
+
+	sigs = make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	brokerOptions, err := mqttOptions()
+	if err != nil {
+		return err
+	}
+
+	var instances []*box
+	var connections []*connection
+	for _, boxConfig := range config.Boxes {
+		instance, errBox := newBox(boxConfig)
+		if errBox != nil {
+			return errBox
+		}
+		if layout == internal.LayoutHomie || len(connections) == 0 {
+			connections = append(connections, newConnection(instance.mqttOptions(brokerOptions)))
+		}
+		current := connections[len(connections)-1]
+		instance.publishChan = current.publishChan
+		current.commands = append(current.commands, instance.commands()...)
+		instances = append(instances, instance)
+	}
+	if layout == internal.LayoutHomie {
+		connections = append(connections, statusConnection(brokerOptions))
+	}
+
+	for _, current := range connections {
+		if errOptions := current.options.Validate(); errOptions != nil {
+			return errOptions
+		}
+	}
+
+	var producers sync.WaitGroup
+	for _, instance := range instances {
+		instance.start(&producers)
+	}
+
+	failed := make(chan *connection, len(connections))
+	for _, current := range connections {
+		current.start(failed)
+	}
+
+	var errMQTT error
+	select {
+	case <-sigs:
+		log.Info("Received SIGINT/SIGTERM")
+	case current := <-failed:
+		errMQTT = current.err
+		// Nobody publishes anymore, the boxes must not block on their way out
+		go func() {
+			for range current.publishChan {
+			}
+		}()
+	}
+
+	// The boxes are stopped first, so their last messages still reach the broker
+	for _, teardown := range teardowns {
+		teardown <- 1
+	}
+	producers.Wait()
+
+	for _, current := range connections {
+		current.teardown <- 1
+		<-current.done
+		if errMQTT == nil {
+			errMQTT = current.err
+		}
+	}
+	return errMQTT
+}
+
+func newTeardown() chan byte {
+	teardown := make(chan byte, 1)
+	teardowns = append(teardowns, teardown)
+	return teardown
+}
+
+func mqttOptions() (internal.MQTTOptions, error) {
+	if mqttUsername == "" {
+		mqttUsername = os.Getenv("MQTT_USERNAME")
+	}
+
+	if mqttPassword == "" {
+		mqttPassword = os.Getenv("MQTT_PASSWORD")
+	}
+
+	if mqttVersion != 3 && mqttVersion != 5 {
+		return internal.MQTTOptions{}, fmt.Errorf("unsupported MQTT version %d", mqttVersion)
+	}
+
+	if mqttTopicAliases > 0 && mqttVersion != 5 {
+		return internal.MQTTOptions{}, fmt.Errorf("topic aliases require MQTT 5")
+	}
+
+	headers := http.Header{}
+	for _, header := range mqttHeaders {
+		name, value, found := strings.Cut(header, ":")
+		if !found {
+			return internal.MQTTOptions{}, fmt.Errorf("invalid header %q, expected name: value", header)
+		}
+		headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	return internal.MQTTOptions{
+		URL:         brokerURL,
+		Headers:     headers,
+		Broker:      brokerHost,
+		Port:        brokerPort,
+		Topic:       mqttTopic,
+		StatusTopic: internal.StatusTopic(topicPrefix),
+		ClientID:    mqttClientID,
+		Username:    mqttUsername,
+		Password:    mqttPassword,
+		TLS:         mqttTLS,
+		Insecure:    mqttInsecure,
+		CAFile:      mqttCAFile,
+		CertFile:    mqttCertFile,
+		KeyFile:     mqttKeyFile,
+		Retention: map[internal.TopicClass]bool{
+			internal.ClassState:     retainState,
+			internal.ClassEvent:     retainEvents,
+			internal.ClassDiscovery: retainDiscovery,
+		},
+		OutboxSize:   mqttOutboxSize,
+		OutboxFile:   mqttOutboxFile,
+		Version:      mqttVersion,
+		TopicAliases: mqttTopicAliases,
+	}, nil
+}
+
+func loadConfig() (internal.Config, error) {
+	if configFile != "" {
+		return internal.LoadConfig(configFile)
+	}
+
 	if username == "" {
 		username = os.Getenv("USERNAME")
 	}
@@ -67,7 +333,7 @@ func do() error {
 	if username == "" || password == "" {
 		ex, err := os.Executable()
 		if err != nil {
-			return err
+			return internal.Config{}, err
 		}
 		exPath := filepath.Dir(ex)
 		envFileAtEx := filepath.Join(exPath, ".env")
@@ -96,51 +362,68 @@ func do() error {
 		os.Exit(1)
 	}
 
-	client := fritzbox.NewFritzClient(baseUrl)
+	return internal.Config{
+		Boxes: []internal.BoxConfig{
+			{
+				BaseURL:         baseUrl,
+				Username:        username,
+				Password:        password,
+				Insecure:        insecure,
+				CAFile:          caFile,
+				Fingerprint:     fingerprint,
+				FingerprintFile: fingerprintFile,
+			},
+		},
+	}, nil
+}
+
+func doOnce(config internal.BoxConfig) error {
+	client, err := fritzbox.NewFritzClient(config.BaseURL, config.TLSOptions())
+	if err != nil {
+		return err
+	}
+
+	tr064Client, err := fritzbox.NewTR064Client(config.BaseURL, config.TLSOptions(), config.Username, config.Password)
+	if err != nil {
+		return err
+	}
 
-	if listOnly {
-		err := internal.ListDevices(client, username, password)
+	if listServices {
+		err := internal.ListServices(tr064Client)
 		if err != nil {
 			printError(err)
 		}
 		return nil
 	}
 
-	sigs = make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-
-	controllerTeardown = make(chan byte, 1)
-	mqttTeardown = make(chan byte, 1)
+	if wakeTarget != "" {
+		return internal.WakeHost(tr064Client, wakeTarget)
+	}
 
-	go func() {
-		<-sigs
-		log.Info("Received SIGINT/SIGTERM")
-		mqttTeardown <- 1
-		controllerTeardown <- 1
-	}()
+	if blockTarget != "" {
+		return internal.BlockHost(tr064Client, blockTarget, true)
+	}
 
-	var wg sync.WaitGroup
+	if unblockTarget != "" {
+		return internal.BlockHost(tr064Client, unblockTarget, false)
+	}
 
-	go func() {
-		defer wg.Done()
-		err := internal.StartController(controllerTeardown, client, username, password)
-		if err != nil {
-			fmt.Println(err)
-		}
-	}()
-	wg.Add(1)
+	if dialNumber != "" {
+		return internal.Dial(tr064Client, dialNumber, dialPhone)
+	}
 
-	go func() {
-		defer wg.Done()
-		err := internal.StartMQTT(mqttTeardown, brokerHost, brokerPort, mqttTopic)
-		if err != nil {
-			fmt.Println(err)
-		}
-	}()
-	wg.Add(1)
+	if hangup {
+		return internal.Hangup(tr064Client)
+	}
 
-	wg.Wait()
+	if exportCalls != "" {
+		return internal.ExportCalls(tr064Client, callsDays, exportCalls, os.Stdout)
+	}
 
+	err = internal.ListDevices(client, config.Username, config.Password)
+	if err != nil {
+		printError(err)
+	}
 	return nil
 }
 
@@ -155,12 +438,80 @@ func Execute() {
 	rootCmd.Flags().SortFlags = false
 	rootCmd.Flags().BoolVar(&showVersion, "version", false, "displays the current version")
 	rootCmd.Flags().BoolVar(&listOnly, "list", false, "list devices and exit")
+	rootCmd.Flags().BoolVar(&listServices, "list-services", false, "list TR-064 services and actions and exit")
+	rootCmd.Flags().StringVar(&wakeTarget, "wake", "", "send wake on LAN to a MAC address or host name and exit")
+	rootCmd.Flags().StringVar(&blockTarget, "block", "", "block internet access of a MAC address or host name and exit")
+	rootCmd.Flags().StringVar(&unblockTarget, "unblock", "", "unblock internet access of a MAC address or host name and exit")
+	rootCmd.Flags().StringVar(&dialNumber, "dial", "", "dial a number with click-to-dial and exit")
+	rootCmd.Flags().BoolVar(&hangup, "hangup", false, "hang up the click-to-dial call and exit")
+	rootCmd.Flags().StringVar(&exportCalls, "export-calls", "", "export the call list as csv or json and exit")
+	rootCmd.Flags().BoolVar(&listBoxes, "list-boxes", false, "list devices found on the network and exit")
+	rootCmd.Flags().StringVar(&configFile, "config", "", "JSON file with named boxes, replaces base url and credentials")
+	rootCmd.Flags().StringVar(&boxName, "box", "", "name of the configured box used by commands that exit, the first one if empty")
 	rootCmd.Flags().StringVar(&baseUrl, "base-url", "https://192.168.178.1", "base url of the device")
+	rootCmd.Flags().BoolVar(&discover, "discover", false, "discover the device on the network instead of using the base url")
+	rootCmd.Flags().BoolVar(&insecure, "insecure", false, "skip TLS certificate verification of the device")
+	rootCmd.Flags().StringVar(&caFile, "ca-file", "", "PEM file with CA certificates to verify the device")
+	rootCmd.Flags().StringVar(&fingerprint, "fingerprint", "", "SHA-256 fingerprint of the pinned device certificate")
+	rootCmd.Flags().StringVar(&fingerprintFile, "fingerprint-file", "", "file to pin the device certificate on first use")
 	rootCmd.Flags().StringVarP(&username, "username", "u", "", "username with smart home rights (env: USERNAME)")
 	rootCmd.Flags().StringVarP(&password, "password", "p", "", "password of the user (env: PASSWORD)")
 	rootCmd.Flags().StringVar(&brokerHost, "broker-host", "localhost", "hostname of the MQTT broker (env: MQTT_BROKER_HOST)")
-	rootCmd.Flags().IntVar(&brokerPort, "broker-port", 1883, "port of the MQTT broker (env: MQTT_BROKER_PORT)")
+	rootCmd.Flags().IntVar(&brokerPort, "broker-port", 0, "port of the MQTT broker, 1883 or 8883 with TLS by default (env: MQTT_BROKER_PORT)")
 	rootCmd.Flags().StringVar(&mqttTopic, "topic", "test", "MQTT topic to subscribe (env: MQTT_BROKER_TOPIC)")
+	rootCmd.Flags().StringVar(&brokerURL, "broker-url", "", "full URL of the MQTT broker, e.g. wss://proxy/mqtt, instead of host and port")
+	rootCmd.Flags().StringArrayVar(&mqttHeaders, "mqtt-header", nil, "header for the WebSocket handshake as name: value, repeatable")
+	rootCmd.Flags().BoolVar(&retainState, "retain-state", true, "publish states, e.g. of devices, WAN and WLAN, as retained messages")
+	rootCmd.Flags().BoolVar(&retainEvents, "retain-events", false, "publish events, e.g. button presses and calls, as retained messages")
+	rootCmd.Flags().BoolVar(&retainDiscovery, "retain-discovery", true, "publish Home Assistant discovery configs as retained messages")
+	rootCmd.Flags().StringVar(&retainedState, "retained-state", "", "file to remember device topics, to clear them for devices removed while the bridge was down")
+	rootCmd.Flags().IntVar(&mqttOutboxSize, "mqtt-outbox-size", 1000, "maximum number of messages queued while the MQTT broker is unreachable")
+	rootCmd.Flags().StringVar(&mqttOutboxFile, "mqtt-outbox-file", "", "file to keep queued messages across restarts")
+	rootCmd.Flags().IntVar(&mqttVersion, "mqtt-version", 3, "MQTT protocol version, 3 for 3.1.1 or 5")
+	rootCmd.Flags().Uint16Var(&mqttTopicAliases, "mqtt-topic-aliases", 0, "maximum number of MQTT 5 topic aliases to use, 0 disables them")
+	rootCmd.Flags().StringVar(&mqttClientID, "mqtt-client-id", "fritze-mqtt", "MQTT client ID, must be unique per bridge")
+	rootCmd.Flags().StringVar(&mqttUsername, "mqtt-username", "", "username for the MQTT broker (env: MQTT_USERNAME)")
+	rootCmd.Flags().StringVar(&mqttPassword, "mqtt-password", "", "password for the MQTT broker (env: MQTT_PASSWORD)")
+	rootCmd.Flags().BoolVar(&mqttTLS, "mqtt-tls", false, "connect to the MQTT broker with TLS")
+	rootCmd.Flags().BoolVar(&mqttInsecure, "mqtt-insecure", false, "skip verification of the MQTT broker certificate")
+	rootCmd.Flags().StringVar(&mqttCAFile, "mqtt-ca-file", "", "CA certificate file to verify the MQTT broker")
+	rootCmd.Flags().StringVar(&mqttCertFile, "mqtt-cert-file", "", "client certificate file for mutual TLS with the MQTT broker")
+	rootCmd.Flags().StringVar(&mqttKeyFile, "mqtt-key-file", "", "client key file for mutual TLS with the MQTT broker")
+	rootCmd.Flags().StringVar(&topicPrefix, "topic-prefix", "fritze", "prefix of all published MQTT topics")
+	rootCmd.Flags().StringVar(&layout, "layout", internal.LayoutDefault, "MQTT topic layout, default or homie")
+	rootCmd.Flags().IntVar(&homieVersion, "homie-version", 4, "Homie convention version of the homie layout, 4 or 5")
+	rootCmd.Flags().StringVar(&homiePrefix, "homie-prefix", "homie", "root topic of the homie layout")
+	rootCmd.Flags().StringVar(&topicTemplate, "topic-template", "", "text/template of device topics, default "+internal.DefaultTopicTemplate)
+	rootCmd.Flags().StringVar(&payloadMode, "payload-mode", internal.PayloadRaw, "payload of device values, raw, json or template")
+	rootCmd.Flags().StringVar(&payloadTemplate, "payload-template", "", "text/template of device payloads for the template payload mode")
+	rootCmd.Flags().BoolVar(&homeAssistant, "homeassistant", false, "publish Home Assistant MQTT discovery configs")
+	rootCmd.Flags().StringVar(&homeAssistantPrefix, "homeassistant-prefix", "homeassistant", "Home Assistant MQTT discovery prefix")
+	rootCmd.Flags().BoolVar(&callMonitor, "call-monitor", false, "publish events of the call monitor on port 1012")
+	rootCmd.Flags().BoolVar(&presence, "presence", false, "publish home/away presence of network hosts")
+	rootCmd.Flags().DurationVar(&presenceInterval, "presence-interval", 30*time.Second, "interval to poll the host table")
+	rootCmd.Flags().DurationVar(&presenceHomeGrace, "presence-home-grace", 0, "time a host has to be active before it is home")
+	rootCmd.Flags().DurationVar(&presenceAwayGrace, "presence-away-grace", 5*time.Minute, "time a host has to be inactive before it is away")
+	rootCmd.Flags().StringSliceVar(&presenceMACs, "presence-mac", nil, "MAC addresses to track, all hosts if empty")
+	rootCmd.Flags().BoolVar(&wan, "wan", false, "publish WAN status, line rates and traffic counters")
+	rootCmd.Flags().DurationVar(&wanInterval, "wan-interval", time.Minute, "interval to poll the WAN status")
+	rootCmd.Flags().BoolVar(&wlan, "wlan", false, "publish WLAN state and allow switching WLANs")
+	rootCmd.Flags().DurationVar(&wlanInterval, "wlan-interval", time.Minute, "interval to poll the WLAN state")
+	rootCmd.Flags().BoolVar(&wakeOnLAN, "wol", false, "allow wake on LAN over MQTT")
+	rootCmd.Flags().BoolVar(&hostFilter, "hostfilter", false, "publish and control internet access of hosts over MQTT")
+	rootCmd.Flags().DurationVar(&hostFilterInterval, "hostfilter-interval", time.Minute, "interval to poll the internet access of hosts")
+	rootCmd.Flags().StringSliceVar(&hostFilterHosts, "hostfilter-host", nil, "MAC addresses or host names to publish the internet access for")
+	rootCmd.Flags().BoolVar(&dial, "click-to-dial", false, "allow dialing and hanging up over MQTT")
+	rootCmd.Flags().BoolVar(&tam, "tam", false, "publish answering machines and allow switching them over MQTT")
+	rootCmd.Flags().DurationVar(&tamInterval, "tam-interval", time.Minute, "interval to poll the answering machines")
+	rootCmd.Flags().BoolVar(&calls, "calls", false, "publish recent calls and the missed call count")
+	rootCmd.Flags().DurationVar(&callsInterval, "calls-interval", 5*time.Minute, "interval to poll the call list")
+	rootCmd.Flags().IntVar(&callsDays, "calls-days", 7, "days of the call list to publish or export")
+	rootCmd.Flags().BoolVar(&system, "system", false, "publish system info and detect reboots of the device")
+	rootCmd.Flags().DurationVar(&systemInterval, "system-interval", time.Minute, "interval to poll the system info")
+	rootCmd.Flags().BoolVar(&eventLog, "eventlog", false, "publish new entries of the device event log")
+	rootCmd.Flags().DurationVar(&eventLogInterval, "eventlog-interval", time.Minute, "interval to poll the device event log")
+	rootCmd.Flags().StringVar(&eventLogState, "eventlog-state", "", "file to remember published event log entries across restarts")
+	rootCmd.Flags().StringVar(&dialPhone, "dial-phone", "", "phone used for click-to-dial, e.g. \"DECT: Kitchen\"")
 	if executeError := rootCmd.Execute(); executeError != nil {
 		os.Exit(1)
 	}
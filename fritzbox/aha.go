@@ -5,6 +5,10 @@ import (
 	"fmt"
 	"github.com/webishdev/fritze-mqtt/log"
 	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -75,16 +79,38 @@ const (
 )
 
 type Device struct {
-	id           int
-	ProductName  string
-	Identifier   string
-	Manufacturer string
-	FwVersion    string
-	Name         string
-	Description  string
-	StateValue   int
-	Triggered    bool
-	Functions    []DeviceFunction
+	id                int
+	ProductName       string
+	Identifier        string // AIN of the unit for HAN-FUN devices
+	DeviceIdentifier  string // AIN of the physical device
+	Manufacturer      string
+	FwVersion         string
+	Name              string
+	Description       string
+	StateValue        int
+	Triggered         bool
+	Functions         []DeviceFunction
+	UnitType          DeviceType
+	Present           bool
+	BatteryLevel      *int
+	Temperature       *float64 // °C
+	Humidity          *int     // %
+	Power             *float64 // W
+	Energy            *int     // Wh
+	TargetTemperature *float64 // °C, nil when the thermostat is switched off or fully open
+	ThermostatMode    string   // off, on (fully open) or auto, empty without a thermostat
+	Level             *int     // %
+	LastPressed       int64    // latest of all buttons
+	Buttons           []Button
+}
+
+// FRITZ!DECT 400 and 440 report the short and long press of a button as buttons of their own, which share the number
+type Button struct {
+	Identifier  string
+	Name        string
+	Number      int
+	LongPress   bool
+	LastPressed int64
 }
 
 type deviceList struct {
@@ -95,21 +121,27 @@ type deviceList struct {
 }
 
 type device struct {
-	Id              int           `xml:"id,attr,omitempty"` // internal id
-	ProductName     string        `xml:"productname,attr,omitempty"`
-	Identifier      string        `xml:"identifier,attr,omitempty"` // AIN, MAC
-	Manufacturer    string        `xml:"manufacturer,attr,omitempty"`
-	FwVersion       string        `xml:"fwversion,attr,omitempty"`
-	FunctionBitmask uint32        `xml:"functionbitmask,attr,omitempty"`
-	Name            string        `xml:"name"`
-	IsLowBattery    *bool         `xml:"batterylow,omitempty"`
-	BatteryLevel    *byte         `xml:"battery,omitempty"`
-	Present         bool          `xml:"present"`
-	TXBusy          bool          `xml:"txbusy"`
-	OnOff           *DeviceOnOff  `xml:"simpleonoff,omitempty"`
-	Alert           *DeviceAlert  `xml:"alert,omitempty"`
-	Button          *DeviceButton `xml:"button,omitempty"`
-	UnitInfo        *unitInfo     `xml:"etsiunitinfo,omitempty"`
+	Id              int                `xml:"id,attr,omitempty"` // internal id
+	ProductName     string             `xml:"productname,attr,omitempty"`
+	Identifier      string             `xml:"identifier,attr,omitempty"` // AIN, MAC
+	Manufacturer    string             `xml:"manufacturer,attr,omitempty"`
+	FwVersion       string             `xml:"fwversion,attr,omitempty"`
+	FunctionBitmask uint32             `xml:"functionbitmask,attr,omitempty"`
+	Name            string             `xml:"name"`
+	IsLowBattery    *bool              `xml:"batterylow,omitempty"`
+	BatteryLevel    *byte              `xml:"battery,omitempty"`
+	Present         bool               `xml:"present"`
+	TXBusy          bool               `xml:"txbusy"`
+	OnOff           *DeviceOnOff       `xml:"simpleonoff,omitempty"`
+	Switch          *DeviceSwitch      `xml:"switch,omitempty"`
+	Alert           *DeviceAlert       `xml:"alert,omitempty"`
+	Buttons         []DeviceButton     `xml:"button,omitempty"`
+	Temperature     *DeviceTemperature `xml:"temperature,omitempty"`
+	Humidity        *DeviceHumidity    `xml:"humidity,omitempty"`
+	PowerMeter      *DevicePowerMeter  `xml:"powermeter,omitempty"`
+	HKR             *DeviceHKR         `xml:"hkr,omitempty"`
+	LevelControl    *DeviceLevel       `xml:"levelcontrol,omitempty"`
+	UnitInfo        *unitInfo          `xml:"etsiunitinfo,omitempty"`
 }
 
 type unitInfo struct {
@@ -141,6 +173,38 @@ type DeviceButton struct {
 	Name        string `xml:"name,omitempty"`
 }
 
+type DeviceTemperature struct {
+	Celsius int `xml:"celsius"` // 0.1 °C
+	Offset  int `xml:"offset"`  // 0.1 °C
+}
+
+type DeviceHumidity struct {
+	RelHumidity int `xml:"rel_humidity"` // %
+}
+
+type DevicePowerMeter struct {
+	Voltage int `xml:"voltage"` // mV
+	Power   int `xml:"power"`   // mW
+	Energy  int `xml:"energy"`  // Wh
+}
+
+type DeviceHKR struct { // Radiator thermostat
+	Actual  int `xml:"tist"`  // 0.5 °C
+	Target  int `xml:"tsoll"` // 0.5 °C, hkrOff or hkrOn
+	Comfort int `xml:"komfort"`
+	Lowered int `xml:"absenk"`
+}
+
+const (
+	hkrOff = 253
+	hkrOn  = 254
+)
+
+type DeviceLevel struct {
+	Level           int `xml:"level"` // 0-255
+	LevelPercentage int `xml:"levelpercentage"`
+}
+
 func getDeviceListInfos(fc *fritzClient, s Session) ([]Device, error) {
 	resp, err := fc.client.Get(fmt.Sprintf("%s/webservices/homeautoswitch.lua?sid=%s&switchcmd=getdevicelistinfos", fc.baseURL, s.GetSID()))
 	if err != nil {
@@ -148,6 +212,13 @@ func getDeviceListInfos(fc *fritzClient, s Session) ([]Device, error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusForbidden {
+		return nil, ErrSessionInvalid
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("getdevicelistinfos failed with status %s", resp.Status)
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -158,21 +229,30 @@ func getDeviceListInfos(fc *fritzClient, s Session) ([]Device, error) {
 		return nil, unmarshalErr
 	}
 
+	log.PrintXML(dl)
+
+	return toDevices(dl), nil
+}
+
+func toDevices(dl deviceList) []Device {
 	idToInternalDevice := map[int]*device{}
 
 	for _, d := range dl.Devices {
 		idToInternalDevice[d.Id] = &d
 	}
 
-	log.PrintXML(dl)
-
 	var devices []Device
 
 	for _, d := range dl.Devices {
-		if d.UnitInfo == nil {
+		relatedDevice := &d
+		unitType := DeviceType(0)
+		if d.UnitInfo != nil {
+			relatedDevice = idToInternalDevice[d.UnitInfo.DeviceID]
+			unitType = d.UnitInfo.UnitType
+		} else if d.FunctionBitmask&(1<<HANFUNDevice) != 0 {
+			// A HAN-FUN device only groups its units, which are listed on their own
 			continue
 		}
-		relatedDevice := idToInternalDevice[d.UnitInfo.DeviceID]
 		if relatedDevice == nil {
 			continue
 		}
@@ -184,6 +264,21 @@ func getDeviceListInfos(fc *fritzClient, s Session) ([]Device, error) {
 		if relatedDevice.Name != d.Name {
 			useName = relatedDevice.Name + " (" + d.Name + ")"
 		}
+		current := Device{
+			id:               relatedDevice.Id,
+			ProductName:      relatedDevice.ProductName,
+			Identifier:       d.Identifier,
+			DeviceIdentifier: relatedDevice.Identifier,
+			Manufacturer:     relatedDevice.Manufacturer,
+			FwVersion:        relatedDevice.FwVersion,
+			Functions:        functions,
+			UnitType:         unitType,
+			Present:          relatedDevice.Present,
+		}
+		if d.Switch != nil {
+			descriptionParts = append(descriptionParts, fmt.Sprintf("switch=%d", d.Switch.State))
+			useState = d.Switch.State
+		}
 		if d.OnOff != nil {
 			descriptionParts = append(descriptionParts, fmt.Sprintf("on_off=%d", d.OnOff.State))
 			useState = d.OnOff.State
@@ -194,34 +289,100 @@ func getDeviceListInfos(fc *fritzClient, s Session) ([]Device, error) {
 			useState = d.Alert.State
 			isTriggered = d.Alert.State == 1
 		}
-		if d.Button != nil {
-			lastPressed := time.Unix(d.Button.LastPressed, 0)
-			descriptionParts = append(descriptionParts, "button")
+		if len(d.Buttons) > 0 {
+			current.Buttons = toButtons(d.Buttons)
+			for _, button := range current.Buttons {
+				current.LastPressed = max(current.LastPressed, button.LastPressed)
+			}
+			lastPressed := time.Unix(current.LastPressed, 0)
+			descriptionParts = append(descriptionParts, fmt.Sprintf("buttons=%d", len(current.Buttons)))
 			descriptionParts = append(descriptionParts, fmt.Sprintf("lastpressed=%s", lastPressed.Format(time.DateTime)))
 		}
+		if d.Temperature != nil {
+			temperature := float64(d.Temperature.Celsius) / 10
+			descriptionParts = append(descriptionParts, fmt.Sprintf("temperature=%.1f°C", temperature))
+			current.Temperature = &temperature
+		}
+		if d.Humidity != nil {
+			humidity := d.Humidity.RelHumidity
+			descriptionParts = append(descriptionParts, fmt.Sprintf("humidity=%d%%", humidity))
+			current.Humidity = &humidity
+		}
+		if d.PowerMeter != nil {
+			power := float64(d.PowerMeter.Power) / 1000
+			energy := d.PowerMeter.Energy
+			descriptionParts = append(descriptionParts, fmt.Sprintf("power=%.2fW, energy=%dWh", power, energy))
+			current.Power = &power
+			current.Energy = &energy
+		}
+		if d.HKR != nil {
+			switch {
+			case d.HKR.Target == hkrOff:
+				descriptionParts = append(descriptionParts, "target=off")
+				current.ThermostatMode = "off"
+			case d.HKR.Target == hkrOn:
+				descriptionParts = append(descriptionParts, "target=on")
+				current.ThermostatMode = "on"
+			case d.HKR.Target >= 16 && d.HKR.Target <= 56:
+				target := float64(d.HKR.Target) / 2
+				descriptionParts = append(descriptionParts, fmt.Sprintf("target=%.1f°C", target))
+				current.TargetTemperature = &target
+				current.ThermostatMode = "auto"
+			}
+			if current.Temperature == nil && d.HKR.Actual > 0 {
+				temperature := float64(d.HKR.Actual) / 2
+				current.Temperature = &temperature
+			}
+		}
+		if d.LevelControl != nil {
+			level := d.LevelControl.LevelPercentage
+			descriptionParts = append(descriptionParts, fmt.Sprintf("level=%d%%", level))
+			current.Level = &level
+		}
 
 		if relatedDevice.BatteryLevel != nil {
 			descriptionParts = append(descriptionParts, fmt.Sprintf("battery=%d%%", *relatedDevice.BatteryLevel))
+			batteryLevel := int(*relatedDevice.BatteryLevel)
+			current.BatteryLevel = &batteryLevel
 		}
 
-		useDescription := strings.Join(descriptionParts, ", ")
-		current := Device{
-			id:           relatedDevice.Id,
-			ProductName:  relatedDevice.ProductName,
-			Identifier:   relatedDevice.Identifier,
-			Manufacturer: relatedDevice.Manufacturer,
-			FwVersion:    relatedDevice.FwVersion,
-			Name:         useName,
-			Description:  useDescription,
-			StateValue:   useState,
-			Triggered:    isTriggered,
-			Functions:    functions,
-		}
+		current.Name = useName
+		current.Description = strings.Join(descriptionParts, ", ")
+		current.StateValue = useState
+		current.Triggered = isTriggered
 
 		devices = append(devices, current)
 	}
 
-	return devices, nil
+	return devices
+}
+
+var buttonNumber = regexp.MustCompile(`#(\d+)`)
+
+// Long presses are named after the button with "lang" appended, e.g. "FRITZ!DECT 400 #1: lang".
+// Renamed buttons lose the #<n>, they are numbered by the suffix of their identifier then, e.g. "09995 0000001-9".
+func toButtons(deviceButtons []DeviceButton) []Button {
+	var buttons []Button
+	for i, b := range deviceButtons {
+		name := strings.ToLower(strings.TrimSpace(b.Name))
+		longPress := strings.HasSuffix(name, "lang") || strings.HasSuffix(name, "long")
+		number := i + 1
+		if match := buttonNumber.FindStringSubmatch(b.Name); match != nil {
+			number, _ = strconv.Atoi(match[1])
+		} else if _, suffix, found := strings.Cut(b.Identifier, "-"); found {
+			if n, err := strconv.Atoi(suffix); err == nil {
+				number = n
+			}
+		}
+		buttons = append(buttons, Button{
+			Identifier:  b.Identifier,
+			Name:        b.Name,
+			Number:      number,
+			LongPress:   longPress,
+			LastPressed: b.LastPressed,
+		})
+	}
+	return buttons
 }
 
 func parseFunctionBitmask(bitmask uint32) []DeviceFunction {
@@ -259,3 +420,32 @@ func isDefinedDeviceFunction(f DeviceFunction) bool {
 		return false
 	}
 }
+
+func switchCommand(fc *fritzClient, s Session, identifier string, command string, parameters url.Values) (string, error) {
+	if parameters == nil {
+		parameters = url.Values{}
+	}
+	parameters.Set("sid", s.GetSID())
+	parameters.Set("ain", strings.ReplaceAll(identifier, " ", ""))
+	parameters.Set("switchcmd", command)
+
+	resp, err := fc.client.Get(fmt.Sprintf("%s/webservices/homeautoswitch.lua?%s", fc.baseURL, parameters.Encode()))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode == http.StatusForbidden {
+		return "", ErrSessionInvalid
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s for %s failed with status %s", command, identifier, resp.Status)
+	}
+
+	return strings.TrimSpace(string(body)), nil
+}
@@ -1,6 +1,7 @@
 package fritzbox
 
 import (
+	"encoding/xml"
 	"fmt"
 	"testing"
 )
@@ -32,3 +33,90 @@ func Test_Bitmask(t *testing.T) {
 		fmt.Println("❌ Bit 6 and/or Bit 8 are not set.")
 	}
 }
+
+func Test_toDevices(t *testing.T) {
+	body := `<devicelist version="1" fwversion="7.57">
+<device identifier="11657 0240192" id="16" functionbitmask="35712" fwversion="04.26" manufacturer="AVM" productname="FRITZ!DECT 200">
+<present>1</present><txbusy>0</txbusy><name>Washing machine</name>
+<switch><state>1</state><mode>manuell</mode><lock>0</lock><devicelock>0</devicelock></switch>
+<simpleonoff><state>1</state></simpleonoff>
+<powermeter><voltage>230051</voltage><power>1520</power><energy>4711</energy></powermeter>
+<temperature><celsius>215</celsius><offset>0</offset></temperature>
+</device>
+<device identifier="13077 0012345" id="406" functionbitmask="1" fwversion="0.0" manufacturer="0x2c3c" productname="HAN-FUN">
+<present>1</present><txbusy>0</txbusy><name>Front door</name><battery>80</battery>
+</device>
+<device identifier="13077 0012345-1" id="2000" functionbitmask="8208" fwversion="0.0" manufacturer="0x2c3c" productname="HAN-FUN">
+<present>1</present><txbusy>0</txbusy><name>Contact</name>
+<etsiunitinfo><etsideviceid>406</etsideviceid><unittype>513</unittype><interfaces>256</interfaces></etsiunitinfo>
+<alert><state>1</state><lastalertchgtimestamp>1752247238</lastalertchgtimestamp></alert>
+</device>
+<device identifier="09995 0000001" id="20" functionbitmask="1048864" fwversion="05.10" manufacturer="AVM" productname="FRITZ!DECT 400">
+<present>1</present><txbusy>0</txbusy><name>Remote</name><battery>100</battery>
+<button identifier="09995 0000001-1" id="5000"><name>FRITZ!DECT 400 #1: kurz</name><lastpressedtimestamp>1752247238</lastpressedtimestamp></button>
+<button identifier="09995 0000001-9" id="5001"><name>FRITZ!DECT 400 #1: lang</name><lastpressedtimestamp>1752247300</lastpressedtimestamp></button>
+</device>
+<device identifier="13979 0878454" id="22" functionbitmask="320" fwversion="05.16" manufacturer="AVM" productname="FRITZ!DECT 301">
+<present>1</present><txbusy>0</txbusy><name>Bathroom</name><battery>70</battery>
+<temperature><celsius>205</celsius><offset>0</offset></temperature>
+<hkr><tist>41</tist><tsoll>253</tsoll><absenk>32</absenk><komfort>42</komfort></hkr>
+</device>
+</devicelist>`
+
+	var dl deviceList
+	if err := xml.Unmarshal([]byte(body), &dl); err != nil {
+		t.Fatal(err)
+	}
+
+	devices := toDevices(dl)
+	if len(devices) != 4 {
+		t.Fatalf("expected 4 devices, got %d", len(devices))
+	}
+
+	outlet := devices[0]
+	if outlet.StateValue != 1 || outlet.Power == nil || *outlet.Power != 1.52 || outlet.Temperature == nil || *outlet.Temperature != 21.5 {
+		t.Errorf("invalid outlet %+v", outlet)
+	}
+
+	contact := devices[1]
+	if contact.Identifier != "13077 0012345-1" || contact.DeviceIdentifier != "13077 0012345" || contact.UnitType != TypeDoorOpenCloseDetector {
+		t.Errorf("invalid contact %+v", contact)
+	}
+	if !contact.Triggered || contact.BatteryLevel == nil || *contact.BatteryLevel != 80 || contact.Name != "Front door (Contact)" {
+		t.Errorf("invalid contact state %+v", contact)
+	}
+
+	remote := devices[2]
+	if len(remote.Buttons) != 2 || remote.LastPressed != 1752247300 {
+		t.Fatalf("invalid remote %+v", remote)
+	}
+	if remote.Buttons[0].Number != 1 || remote.Buttons[0].LongPress || remote.Buttons[1].Number != 1 || !remote.Buttons[1].LongPress {
+		t.Errorf("expected short and long press of the same button, got %+v", remote.Buttons)
+	}
+
+	thermostat := devices[3]
+	if thermostat.ThermostatMode != "off" || thermostat.TargetTemperature != nil {
+		t.Errorf("expected the thermostat to be off, got %+v", thermostat)
+	}
+}
+
+func Test_toButtons(t *testing.T) {
+	buttons := toButtons([]DeviceButton{
+		{Identifier: "09995 0000440-1", Name: "FRITZ!DECT 440 #1"},
+		{Identifier: "09995 0000440-3", Name: "FRITZ!DECT 440 #2"},
+		{Identifier: "09995 0000001-1", Name: "FRITZ!DECT 400 #1: kurz"},
+		{Identifier: "09995 0000001-9", Name: "FRITZ!DECT 400 #1: lang"},
+		{Identifier: "09995 0000002-5", Name: "Licht an"},
+		{Identifier: "09995 0000002-7", Name: "Licht lang"},
+	})
+
+	expected := []struct {
+		number    int
+		longPress bool
+	}{{1, false}, {2, false}, {1, false}, {1, true}, {5, false}, {7, true}}
+	for i, button := range buttons {
+		if button.Number != expected[i].number || button.LongPress != expected[i].longPress {
+			t.Errorf("expected %+v for %s, got %+v", expected[i], button.Name, button)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package fritzbox
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type CallEventType string
+
+const (
+	CallEventRing       CallEventType = "RING"
+	CallEventCall       CallEventType = "CALL"
+	CallEventConnect    CallEventType = "CONNECT"
+	CallEventDisconnect CallEventType = "DISCONNECT"
+)
+
+type CallEvent struct {
+	Time         time.Time     `json:"time"`
+	Type         CallEventType `json:"type"`
+	ConnectionID int           `json:"connectionId"`
+	Extension    string        `json:"extension,omitempty"`
+	Caller       string        `json:"caller,omitempty"`
+	Callee       string        `json:"callee,omitempty"`
+	Line         string        `json:"line,omitempty"`
+	Duration     int           `json:"duration,omitempty"` // seconds
+}
+
+// The call monitor has to be enabled once by dialing #96*5* on a connected phone
+func CallMonitorAddress(baseURL string) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(u.Hostname(), "1012"), nil
+}
+
+// Lines look like
+//
+//	09.07.25 18:12:01;RING;0;0301234567;987654;SIP0;
+//	09.07.25 18:12:01;CALL;1;10;987654;0301234567;SIP0;
+//	09.07.25 18:12:05;CONNECT;1;10;0301234567;
+//	09.07.25 18:12:45;DISCONNECT;1;40;
+func ParseCallEvent(line string) (CallEvent, error) {
+	parts := strings.Split(strings.TrimRight(strings.TrimSpace(line), ";"), ";")
+	if len(parts) < 3 {
+		return CallEvent{}, fmt.Errorf("invalid call monitor line: %s", line)
+	}
+
+	eventTime, err := time.ParseInLocation("02.01.06 15:04:05", parts[0], time.Local)
+	if err != nil {
+		return CallEvent{}, err
+	}
+
+	connectionID, err := parseInt(parts[2])
+	if err != nil {
+		return CallEvent{}, err
+	}
+
+	event := CallEvent{
+		Time:         eventTime,
+		Type:         CallEventType(parts[1]),
+		ConnectionID: connectionID,
+	}
+
+	field := func(i int) string {
+		if i < len(parts) {
+			return parts[i]
+		}
+		return ""
+	}
+
+	switch event.Type {
+	case CallEventRing:
+		event.Caller = field(3)
+		event.Callee = field(4)
+		event.Line = field(5)
+	case CallEventCall:
+		event.Extension = field(3)
+		event.Caller = field(4)
+		event.Callee = field(5)
+		event.Line = field(6)
+	case CallEventConnect:
+		event.Extension = field(3)
+		event.Caller = field(4)
+	case CallEventDisconnect:
+		duration, errDuration := parseInt(field(3))
+		if errDuration != nil {
+			return CallEvent{}, errDuration
+		}
+		event.Duration = duration
+	default:
+		return CallEvent{}, fmt.Errorf("unknown call monitor event %s", parts[1])
+	}
+
+	return event, nil
+}
@@ -0,0 +1,36 @@
+package fritzbox
+
+import "testing"
+
+func Test_ParseCallEvent(t *testing.T) {
+	ring, err := ParseCallEvent("09.07.25 18:12:01;RING;0;0301234567;987654;SIP0;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ring.Type != CallEventRing || ring.Caller != "0301234567" || ring.Callee != "987654" || ring.Line != "SIP0" {
+		t.Errorf("invalid ring event %+v", ring)
+	}
+	if ring.Time.Year() != 2025 || ring.Time.Month() != 7 || ring.Time.Day() != 9 {
+		t.Errorf("invalid time %s", ring.Time)
+	}
+
+	call, err := ParseCallEvent("09.07.25 18:12:01;CALL;1;10;987654;0301234567;SIP0;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if call.Type != CallEventCall || call.ConnectionID != 1 || call.Extension != "10" || call.Callee != "0301234567" {
+		t.Errorf("invalid call event %+v", call)
+	}
+
+	disconnect, err := ParseCallEvent("09.07.25 18:12:45;DISCONNECT;1;40;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if disconnect.Type != CallEventDisconnect || disconnect.Duration != 40 {
+		t.Errorf("invalid disconnect event %+v", disconnect)
+	}
+
+	if _, err := ParseCallEvent("09.07.25 18:12:45;UNKNOWN;1;"); err == nil {
+		t.Error("unknown event accepted")
+	}
+}
@@ -1,11 +1,12 @@
 package fritzbox
 
 import (
-	"crypto/tls"
 	"fmt"
 	"github.com/webishdev/fritze-mqtt/log"
+	"math"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 )
 
@@ -13,6 +14,11 @@ type FritzClient interface {
 	Login(username string, password string) (Session, error)
 	Logout(s Session) error
 	GetDevices(s Session) ([]Device, error)
+	SetSimpleOnOff(s Session, identifier string, on bool) error
+	SetTargetTemperature(s Session, identifier string, celsius float64) error
+	SetThermostat(s Session, identifier string, on bool) error
+	SetBlind(s Session, identifier string, target string) error
+	SetLevelPercentage(s Session, identifier string, percent int) error
 }
 
 type fritzClient struct {
@@ -20,17 +26,16 @@ type fritzClient struct {
 	client  *http.Client
 }
 
-func NewFritzClient(baseURL string) FritzClient {
+func NewFritzClient(baseURL string, tlsOptions TLSOptions) (FritzClient, error) {
+	httpClient, err := newHTTPClient(baseURL, tlsOptions)
+	if err != nil {
+		return nil, err
+	}
+
 	return &fritzClient{
 		baseURL: baseURL,
-		client: &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: true,
-				},
-			},
-		},
-	}
+		client:  httpClient,
+	}, nil
 }
 
 func (fc *fritzClient) Login(username string, password string) (Session, error) {
@@ -108,3 +113,53 @@ func (fc *fritzClient) GetDevices(s Session) ([]Device, error) {
 	s.Used()
 	return getDeviceListInfos(fc, s)
 }
+
+func (fc *fritzClient) SetSimpleOnOff(s Session, identifier string, on bool) error {
+	s.Used()
+	onOff := "0"
+	if on {
+		onOff = "1"
+	}
+	_, err := switchCommand(fc, s, identifier, "setsimpleonoff", url.Values{"onoff": {onOff}})
+	return err
+}
+
+func (fc *fritzClient) SetTargetTemperature(s Session, identifier string, celsius float64) error {
+	s.Used()
+	// The thermostat accepts 8 to 28 °C in steps of 0.5 °C
+	param := int(math.Round(celsius * 2))
+	param = max(16, min(56, param))
+	_, err := switchCommand(fc, s, identifier, "sethkrtsoll", url.Values{"param": {strconv.Itoa(param)}})
+	return err
+}
+
+// Switched on the valve is fully open, switched off only the frost protection remains
+func (fc *fritzClient) SetThermostat(s Session, identifier string, on bool) error {
+	s.Used()
+	param := hkrOff
+	if on {
+		param = hkrOn
+	}
+	_, err := switchCommand(fc, s, identifier, "sethkrtsoll", url.Values{"param": {strconv.Itoa(param)}})
+	return err
+}
+
+func (fc *fritzClient) SetBlind(s Session, identifier string, target string) error {
+	s.Used()
+	switch target {
+	case "open", "close", "stop":
+	default:
+		return fmt.Errorf("invalid blind target %s, use open, close or stop", target)
+	}
+	_, err := switchCommand(fc, s, identifier, "setblind", url.Values{"target": {target}})
+	return err
+}
+
+func (fc *fritzClient) SetLevelPercentage(s Session, identifier string, percent int) error {
+	s.Used()
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("invalid level %d%%", percent)
+	}
+	_, err := switchCommand(fc, s, identifier, "setlevelpercentage", url.Values{"level": {strconv.Itoa(percent)}})
+	return err
+}
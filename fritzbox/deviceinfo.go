@@ -0,0 +1,28 @@
+package fritzbox
+
+const ServiceDeviceInfo = "urn:dslforum-org:service:DeviceInfo:1"
+
+type SystemInfo struct {
+	Manufacturer    string `json:"manufacturer"`
+	Model           string `json:"model"`
+	SerialNumber    string `json:"serial"`
+	SoftwareVersion string `json:"firmware"`
+	HardwareVersion string `json:"hardware"`
+	Uptime          uint64 `json:"uptime"` // seconds
+}
+
+func GetSystemInfo(tc TR064Client) (SystemInfo, error) {
+	result, err := tc.Call(ServiceDeviceInfo, "GetInfo", nil)
+	if err != nil {
+		return SystemInfo{}, err
+	}
+
+	return SystemInfo{
+		Manufacturer:    result["NewManufacturerName"],
+		Model:           result["NewModelName"],
+		SerialNumber:    result["NewSerialNumber"],
+		SoftwareVersion: result["NewSoftwareVersion"],
+		HardwareVersion: result["NewHardwareVersion"],
+		Uptime:          parseUint(result["NewUpTime"]),
+	}, nil
+}
@@ -0,0 +1,150 @@
+package fritzbox
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type digestAuth struct {
+	mutex     sync.Mutex
+	realm     string
+	nonce     string
+	opaque    string
+	qop       string
+	algorithm string
+	count     int
+}
+
+func (d *digestAuth) update(header string) error {
+	if !strings.HasPrefix(header, "Digest ") {
+		return fmt.Errorf("unsupported authentication challenge: %s", header)
+	}
+
+	parameters := parseDigestParameters(strings.TrimPrefix(header, "Digest "))
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.realm = parameters["realm"]
+	d.nonce = parameters["nonce"]
+	d.opaque = parameters["opaque"]
+	d.algorithm = parameters["algorithm"]
+	d.qop = ""
+	d.count = 0
+
+	for _, qop := range strings.Split(parameters["qop"], ",") {
+		if strings.TrimSpace(qop) == "auth" {
+			d.qop = "auth"
+		}
+	}
+
+	if d.algorithm != "" && !strings.EqualFold(d.algorithm, "MD5") {
+		return fmt.Errorf("unsupported digest algorithm %s", d.algorithm)
+	}
+
+	return nil
+}
+
+func (d *digestAuth) reset() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.nonce = ""
+	d.count = 0
+}
+
+func (d *digestAuth) authorize(req *http.Request, username string, password string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.nonce == "" {
+		return
+	}
+
+	d.count++
+	nc := fmt.Sprintf("%08x", d.count)
+	cnonce := newCNonce()
+	uri := req.URL.RequestURI()
+
+	response := digestResponse(username, password, d.realm, d.nonce, req.Method, uri, d.qop, nc, cnonce)
+
+	parts := []string{
+		fmt.Sprintf(`username="%s"`, username),
+		fmt.Sprintf(`realm="%s"`, d.realm),
+		fmt.Sprintf(`nonce="%s"`, d.nonce),
+		fmt.Sprintf(`uri="%s"`, uri),
+		fmt.Sprintf(`response="%s"`, response),
+	}
+	if d.algorithm != "" {
+		parts = append(parts, fmt.Sprintf("algorithm=%s", d.algorithm))
+	}
+	if d.opaque != "" {
+		parts = append(parts, fmt.Sprintf(`opaque="%s"`, d.opaque))
+	}
+	if d.qop != "" {
+		parts = append(parts, fmt.Sprintf("qop=%s", d.qop), fmt.Sprintf("nc=%s", nc), fmt.Sprintf(`cnonce="%s"`, cnonce))
+	}
+
+	req.Header.Set("Authorization", "Digest "+strings.Join(parts, ", "))
+}
+
+func digestResponse(username string, password string, realm string, nonce string, method string, uri string, qop string, nc string, cnonce string) string {
+	ha1 := md5Hex(fmt.Sprintf("%s:%s:%s", username, realm, password))
+	ha2 := md5Hex(fmt.Sprintf("%s:%s", method, uri))
+
+	if qop == "" {
+		return md5Hex(fmt.Sprintf("%s:%s:%s", ha1, nonce, ha2))
+	}
+
+	return md5Hex(fmt.Sprintf("%s:%s:%s:%s:%s:%s", ha1, nonce, nc, cnonce, qop, ha2))
+}
+
+func parseDigestParameters(s string) map[string]string {
+	parameters := map[string]string{}
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ,")
+		eq := strings.Index(s, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.Index(s[1:], `"`)
+			if end < 0 {
+				value = s[1:]
+				s = ""
+			} else {
+				value = s[1 : end+1]
+				s = s[end+2:]
+			}
+		} else {
+			end := strings.Index(s, ",")
+			if end < 0 {
+				end = len(s)
+			}
+			value = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+		parameters[strings.ToLower(key)] = value
+	}
+	return parameters
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func newCNonce() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,152 @@
+package fritzbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ssdpSearchTargets = []string{
+	"urn:dslforum-org:device:InternetGatewayDevice:1",
+	"urn:schemas-upnp-org:device:InternetGatewayDevice:1",
+	"urn:schemas-upnp-org:device:InternetGatewayDevice:2",
+}
+
+type DiscoveredBox struct {
+	Name     string
+	Model    string
+	BaseURL  string
+	Location string
+}
+
+type ssdpResponse struct {
+	Location string
+	Server   string
+}
+
+func Discover(timeout time.Duration) ([]DiscoveredBox, error) {
+	locations, err := searchSSDP(timeout)
+	if err != nil {
+		log.Warn("Could not search the network: %s", err)
+	}
+
+	// Without multicast, e.g. in containers, the box can still be found by its well known name
+	fritzBoxAddresses, _ := net.LookupHost("fritz.box")
+	if len(locations) == 0 && len(fritzBoxAddresses) > 0 {
+		locations = append(locations, fmt.Sprintf("http://%s/tr64desc.xml", net.JoinHostPort(fritzBoxAddresses[0], "49000")))
+	}
+
+	client := &http.Client{Timeout: timeout}
+	var boxes []DiscoveredBox
+	for _, location := range locations {
+		box, errDescribe := describeBox(client, location, fritzBoxAddresses)
+		if errDescribe != nil {
+			log.Debug("Ignoring %s: %s", location, errDescribe)
+			continue
+		}
+		boxes = append(boxes, box)
+	}
+
+	return boxes, nil
+}
+
+func searchSSDP(timeout time.Duration) ([]string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	multicast := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+	for _, st := range ssdpSearchTargets {
+		request := fmt.Sprintf("M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nMX: 2\r\nST: %s\r\n\r\n", st)
+		if _, errWrite := conn.WriteTo([]byte(request), multicast); errWrite != nil {
+			return nil, errWrite
+		}
+	}
+
+	if errDeadline := conn.SetReadDeadline(time.Now().Add(timeout)); errDeadline != nil {
+		return nil, errDeadline
+	}
+
+	var locations []string
+	buffer := make([]byte, 2048)
+	for {
+		n, _, errRead := conn.ReadFrom(buffer)
+		if errRead != nil {
+			if netErr, ok := errRead.(net.Error); ok && netErr.Timeout() {
+				break
+			}
+			return nil, errRead
+		}
+
+		response, errParse := parseSSDPResponse(buffer[:n])
+		if errParse != nil {
+			continue
+		}
+		if !strings.Contains(response.Server, "AVM") && !strings.Contains(response.Server, "FRITZ!Box") {
+			continue
+		}
+		if !slices.Contains(locations, response.Location) {
+			locations = append(locations, response.Location)
+		}
+	}
+
+	return locations, nil
+}
+
+func parseSSDPResponse(data []byte) (ssdpResponse, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), nil)
+	if err != nil {
+		return ssdpResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return ssdpResponse{}, fmt.Errorf("no location in SSDP response")
+	}
+
+	return ssdpResponse{
+		Location: location,
+		Server:   resp.Header.Get("Server"),
+	}, nil
+}
+
+func describeBox(client *http.Client, location string, fritzBoxAddresses []string) (DiscoveredBox, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return DiscoveredBox{}, err
+	}
+
+	resp, err := client.Get(location)
+	if err != nil {
+		return DiscoveredBox{}, err
+	}
+	defer resp.Body.Close()
+
+	var description tr064Description
+	if errDecode := xml.NewDecoder(resp.Body).Decode(&description); errDecode != nil {
+		return DiscoveredBox{}, errDecode
+	}
+
+	host := u.Hostname()
+	if slices.Contains(fritzBoxAddresses, host) {
+		host = "fritz.box"
+	}
+
+	return DiscoveredBox{
+		Name:     description.Device.FriendlyName,
+		Model:    description.Device.ModelName,
+		BaseURL:  fmt.Sprintf("https://%s", host),
+		Location: location,
+	}, nil
+}
@@ -0,0 +1,27 @@
+package fritzbox
+
+import "testing"
+
+func Test_parseSSDPResponse(t *testing.T) {
+	data := "HTTP/1.1 200 OK\r\n" +
+		"LOCATION: http://192.168.178.1:49000/tr64desc.xml\r\n" +
+		"SERVER: FRITZ!Box 7590 UPnP/1.0 AVM FRITZ!Box 7590 154.07.57\r\n" +
+		"CACHE-CONTROL: max-age=1800\r\n" +
+		"EXT:\r\n" +
+		"ST: urn:dslforum-org:device:InternetGatewayDevice:1\r\n" +
+		"USN: uuid:739f2409-bccb-40e7-8e6c-3431C4D1A2B3::urn:dslforum-org:device:InternetGatewayDevice:1\r\n" +
+		"\r\n"
+
+	response, err := parseSSDPResponse([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Location != "http://192.168.178.1:49000/tr64desc.xml" {
+		t.Errorf("invalid location %s", response.Location)
+	}
+
+	if response.Server != "FRITZ!Box 7590 UPnP/1.0 AVM FRITZ!Box 7590 154.07.57" {
+		t.Errorf("invalid server %s", response.Server)
+	}
+}
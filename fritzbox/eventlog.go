@@ -0,0 +1,102 @@
+package fritzbox
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// Newer firmware numbers the entries and names their group, e.g. sys, net or fon
+type LogEntry struct {
+	ID      int       `json:"id,omitempty"`
+	Group   string    `json:"group,omitempty"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+type deviceLog struct {
+	Items []deviceLogItem `xml:"Item"`
+}
+
+type deviceLogItem struct {
+	ID      int    `xml:"id"`
+	Group   string `xml:"group"`
+	Date    string `xml:"date"` // 09.07.25
+	Time    string `xml:"time"` // 18:12:01
+	Message string `xml:"msg"`
+}
+
+// The FRITZ!Box logs its local time without a zone, it is assumed to be the one of the bridge.
+// Entries are returned oldest first.
+func GetDeviceLog(tc TR064Client) ([]LogEntry, error) {
+	result, err := tc.Call(ServiceDeviceInfo, "X_AVM-DE_GetDeviceLogPath", nil)
+	if isInvalidAction(err) {
+		// Older firmware only has the plain text log
+		textResult, errText := tc.Call(ServiceDeviceInfo, "GetDeviceLog", nil)
+		if errText != nil {
+			return nil, errText
+		}
+		return parseDeviceLog(textResult["NewDeviceLog"]), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var dl deviceLog
+	if errLog := tc.GetXML(result["NewDeviceLogPath"], &dl); errLog != nil {
+		return nil, errLog
+	}
+
+	return toLogEntries(dl), nil
+}
+
+func toLogEntries(dl deviceLog) []LogEntry {
+	var entries []LogEntry
+	for _, item := range dl.Items {
+		entryTime, _ := time.ParseInLocation("02.01.06 15:04:05", item.Date+" "+item.Time, time.Local)
+		entries = append(entries, LogEntry{
+			ID:      item.ID,
+			Group:   item.Group,
+			Time:    entryTime,
+			Message: strings.TrimSpace(item.Message),
+		})
+	}
+
+	slices.SortStableFunc(entries, func(a, b LogEntry) int {
+		return a.ID - b.ID
+	})
+
+	return entries
+}
+
+// The device log lists the newest entry first, each line looks like
+//
+//	09.07.25 18:12:01 Anmeldung der Benutzerin admin an der FRITZ!Box-Benutzeroberfläche von IP-Adresse 192.168.178.20.
+func parseDeviceLog(text string) []LogEntry {
+	var entries []LogEntry
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) < 18 {
+			continue
+		}
+
+		entryTime, err := time.ParseInLocation("02.01.06 15:04:05", line[:17], time.Local)
+		if err != nil {
+			if len(entries) > 0 {
+				// Continuation of a multi-line message
+				entries[len(entries)-1].Message += " " + line
+			}
+			continue
+		}
+
+		entries = append(entries, LogEntry{
+			Time:    entryTime,
+			Message: strings.TrimSpace(line[17:]),
+		})
+	}
+
+	// The order of the lines is kept, the clock of the FRITZ!Box may have jumped in between
+	slices.Reverse(entries)
+
+	return entries
+}
@@ -0,0 +1,46 @@
+package fritzbox
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func Test_parseDeviceLog(t *testing.T) {
+	text := `09.07.25 18:12:01 Anmeldung der Benutzerin admin an der FRITZ!Box-Benutzeroberfläche von IP-Adresse 192.168.178.20.
+09.07.25 17:55:40 Smart-Home-Gerät "Haustür" angemeldet.
+08.07.25 03:10:12 DSL ist verfügbar (DSL-Synchronisierung besteht mit 250000/40000 kbit/s).`
+
+	entries := parseDeviceLog(text)
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+
+	if entries[0].Time.Day() != 8 || entries[2].Time.Hour() != 18 {
+		t.Errorf("entries not sorted oldest first %+v", entries)
+	}
+
+	if entries[1].Message != `Smart-Home-Gerät "Haustür" angemeldet.` {
+		t.Errorf("invalid message %s", entries[1].Message)
+	}
+}
+
+func Test_toLogEntries(t *testing.T) {
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<List>
+<Item><id>1042</id><group>sys</group><date>09.07.25</date><time>18:12:01</time><msg>Anmeldung der Benutzerin admin an der FRITZ!Box-Benutzeroberfläche.</msg></Item>
+<Item><id>1041</id><group>net</group><date>09.07.25</date><time>18:12:01</time><msg>WLAN-Gerät angemeldet.</msg></Item>
+</List>`
+
+	var dl deviceLog
+	if err := xml.Unmarshal([]byte(body), &dl); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := toLogEntries(dl)
+	if len(entries) != 2 || entries[0].ID != 1041 || entries[0].Group != "net" || entries[1].ID != 1042 {
+		t.Fatalf("expected entries ordered by id, got %+v", entries)
+	}
+	if entries[1].Time.Hour() != 18 || entries[1].Message != "Anmeldung der Benutzerin admin an der FRITZ!Box-Benutzeroberfläche." {
+		t.Errorf("invalid entry %+v", entries[1])
+	}
+}
@@ -0,0 +1,25 @@
+package fritzbox
+
+import "fmt"
+
+const ServiceHostFilter = "urn:dslforum-org:service:X_AVM-DE_HostFilter:1"
+
+func IsInternetBlocked(tc TR064Client, ip string) (bool, error) {
+	result, err := tc.Call(ServiceHostFilter, "GetWANAccessByIP", map[string]string{"NewIPv4Address": ip})
+	if err != nil {
+		return false, err
+	}
+	return result["NewDisallow"] == "1", nil
+}
+
+func SetInternetBlocked(tc TR064Client, ip string, blocked bool) error {
+	if ip == "" {
+		return fmt.Errorf("host has no IPv4 address")
+	}
+	disallow := "0"
+	if blocked {
+		disallow = "1"
+	}
+	_, err := tc.Call(ServiceHostFilter, "DisallowWANAccessByIP", map[string]string{"NewIPv4Address": ip, "NewDisallow": disallow})
+	return err
+}
@@ -0,0 +1,127 @@
+package fritzbox
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const ServiceHosts = "urn:dslforum-org:service:Hosts:1"
+
+type Host struct {
+	MACAddress    string `json:"mac"`
+	IPAddress     string `json:"ip"`
+	HostName      string `json:"name"`
+	InterfaceType string `json:"interface"`
+	Active        bool   `json:"active"`
+}
+
+type hostList struct {
+	XMLName xml.Name   `xml:"List"`
+	Items   []hostItem `xml:"Item"`
+}
+
+type hostItem struct {
+	IPAddress     string `xml:"IPAddress"`
+	MACAddress    string `xml:"MACAddress"`
+	Active        string `xml:"Active"`
+	HostName      string `xml:"HostName"`
+	InterfaceType string `xml:"InterfaceType"`
+}
+
+func GetHosts(tc TR064Client) ([]Host, error) {
+	result, err := tc.Call(ServiceHosts, "X_AVM-DE_GetHostListPath", nil)
+	if isInvalidAction(err) {
+		// Older firmware has no host list file, fall back to reading entry by entry
+		return getGenericHosts(tc)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var hl hostList
+	if errList := tc.GetXML(result["NewX_AVM-DE_HostListPath"], &hl); errList != nil {
+		return nil, errList
+	}
+
+	var hosts []Host
+	for _, item := range hl.Items {
+		hosts = append(hosts, Host{
+			MACAddress:    item.MACAddress,
+			IPAddress:     item.IPAddress,
+			HostName:      item.HostName,
+			InterfaceType: item.InterfaceType,
+			Active:        item.Active == "1",
+		})
+	}
+
+	return hosts, nil
+}
+
+func getGenericHosts(tc TR064Client) ([]Host, error) {
+	result, err := tc.Call(ServiceHosts, "GetHostNumberOfEntries", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := parseInt(result["NewHostNumberOfEntries"])
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []Host
+	for i := 0; i < count; i++ {
+		entry, errEntry := tc.Call(ServiceHosts, "GetGenericHostEntry", map[string]string{"NewIndex": strconv.Itoa(i)})
+		if errEntry != nil {
+			return nil, errEntry
+		}
+		hosts = append(hosts, Host{
+			MACAddress:    entry["NewMACAddress"],
+			IPAddress:     entry["NewIPAddress"],
+			HostName:      entry["NewHostName"],
+			InterfaceType: entry["NewInterfaceType"],
+			Active:        entry["NewActive"] == "1",
+		})
+	}
+
+	return hosts, nil
+}
+
+func NormalizeMAC(mac string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(mac), "-", ":"))
+}
+
+func ResolveHost(tc TR064Client, nameOrMAC string) (Host, error) {
+	if _, err := net.ParseMAC(strings.TrimSpace(nameOrMAC)); err == nil {
+		mac := NormalizeMAC(nameOrMAC)
+		hosts, errHosts := GetHosts(tc)
+		if errHosts == nil {
+			for _, host := range hosts {
+				if NormalizeMAC(host.MACAddress) == mac {
+					return host, nil
+				}
+			}
+		}
+		return Host{MACAddress: mac}, nil
+	}
+
+	hosts, err := GetHosts(tc)
+	if err != nil {
+		return Host{}, err
+	}
+
+	for _, host := range hosts {
+		if strings.EqualFold(host.HostName, strings.TrimSpace(nameOrMAC)) {
+			return host, nil
+		}
+	}
+
+	return Host{}, fmt.Errorf("unknown host %s", nameOrMAC)
+}
+
+func WakeOnLAN(tc TR064Client, mac string) error {
+	_, err := tc.Call(ServiceHosts, "X_AVM-DE_WakeOnLANByMACAddress", map[string]string{"NewMACAddress": NormalizeMAC(mac)})
+	return err
+}
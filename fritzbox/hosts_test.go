@@ -0,0 +1,58 @@
+package fritzbox
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func Test_hostList(t *testing.T) {
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<List>
+<Item><Index>1</Index><IPAddress>192.168.178.20</IPAddress><MACAddress>AA:BB:CC:DD:EE:01</MACAddress><Active>1</Active><HostName>phone</HostName><InterfaceType>802.11</InterfaceType></Item>
+<Item><Index>2</Index><IPAddress>192.168.178.21</IPAddress><MACAddress>AA:BB:CC:DD:EE:02</MACAddress><Active>0</Active><HostName>laptop</HostName><InterfaceType>Ethernet</InterfaceType></Item>
+</List>`
+
+	var hl hostList
+	if err := xml.Unmarshal([]byte(body), &hl); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hl.Items) != 2 {
+		t.Fatalf("expected 2 hosts, got %d", len(hl.Items))
+	}
+
+	if hl.Items[0].HostName != "phone" || hl.Items[0].Active != "1" || hl.Items[1].MACAddress != "AA:BB:CC:DD:EE:02" {
+		t.Errorf("invalid hosts %+v", hl.Items)
+	}
+
+	if NormalizeMAC(" aa-bb-cc-dd-ee-01") != "AA:BB:CC:DD:EE:01" {
+		t.Errorf("invalid normalized mac %s", NormalizeMAC(" aa-bb-cc-dd-ee-01"))
+	}
+}
+
+type hostsClient struct {
+	TR064Client
+	listErr error
+}
+
+func (c *hostsClient) Call(_ string, action string, _ map[string]string) (map[string]string, error) {
+	switch action {
+	case "X_AVM-DE_GetHostListPath":
+		return nil, c.listErr
+	case "GetHostNumberOfEntries":
+		return map[string]string{"NewHostNumberOfEntries": "1"}, nil
+	default:
+		return map[string]string{"NewMACAddress": "AA:BB:CC:DD:EE:01", "NewActive": "1"}, nil
+	}
+}
+
+func Test_GetHostsFallback(t *testing.T) {
+	hosts, err := GetHosts(&hostsClient{listErr: &TR064Error{Code: errorInvalidAction, Description: "Invalid Action"}})
+	if err != nil || len(hosts) != 1 || !hosts[0].Active {
+		t.Errorf("expected the generic host entries, got %v %v", hosts, err)
+	}
+
+	if _, err := GetHosts(&hostsClient{listErr: &TR064Error{Code: 820, Description: "Internal Error"}}); err == nil {
+		t.Error("other faults must not fall back to the generic host entries")
+	}
+}
@@ -0,0 +1,225 @@
+package fritzbox
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/log"
+	"strings"
+	"time"
+)
+
+const ServiceOnTel = "urn:dslforum-org:service:X_AVM-DE_OnTel:1"
+
+type CallType string
+
+const (
+	CallIncoming CallType = "incoming"
+	CallMissed   CallType = "missed"
+	CallOutgoing CallType = "outgoing"
+	CallRejected CallType = "rejected"
+	CallActive   CallType = "active"
+)
+
+type Call struct {
+	ID       int       `json:"id"`
+	Type     CallType  `json:"type"`
+	Number   string    `json:"number"`
+	Name     string    `json:"name,omitempty"`
+	Own      string    `json:"own"`
+	Device   string    `json:"device,omitempty"`
+	Time     time.Time `json:"time"`
+	Duration string    `json:"duration"`
+}
+
+// Numbers are compared in national format, the country code of the FRITZ!Box tells which international ones are national
+type Phonebook struct {
+	countryCode string
+	names       map[string]string
+}
+
+func NewPhonebook(countryCode string) Phonebook {
+	return Phonebook{countryCode: countryCode, names: map[string]string{}}
+}
+
+type callList struct {
+	XMLName xml.Name   `xml:"root"`
+	Calls   []callItem `xml:"Call"`
+}
+
+type callItem struct {
+	Id           int    `xml:"Id"`
+	Type         int    `xml:"Type"`
+	Called       string `xml:"Called"`
+	Caller       string `xml:"Caller"`
+	CallerNumber string `xml:"CallerNumber"`
+	CalledNumber string `xml:"CalledNumber"`
+	Name         string `xml:"Name"`
+	Device       string `xml:"Device"`
+	Date         string `xml:"Date"`
+	Duration     string `xml:"Duration"`
+}
+
+type phonebooks struct {
+	XMLName    xml.Name        `xml:"phonebooks"`
+	Phonebooks []phonebookItem `xml:"phonebook"`
+}
+
+type phonebookItem struct {
+	Name     string    `xml:"name,attr"`
+	Contacts []contact `xml:"contact"`
+}
+
+type contact struct {
+	RealName string   `xml:"person>realName"`
+	Numbers  []string `xml:"telephony>number"`
+}
+
+func GetCalls(tc TR064Client, days int) ([]Call, error) {
+	result, err := tc.Call(ServiceOnTel, "GetCallList", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	callListURL := result["NewCallListURL"]
+	if days > 0 {
+		callListURL = fmt.Sprintf("%s&days=%d", callListURL, days)
+	}
+
+	var cl callList
+	if errList := tc.GetXML(callListURL, &cl); errList != nil {
+		return nil, errList
+	}
+
+	return toCalls(cl), nil
+}
+
+func GetPhonebook(tc TR064Client) (Phonebook, error) {
+	result, err := tc.Call(ServiceOnTel, "GetPhonebookList", nil)
+	if err != nil {
+		return Phonebook{}, err
+	}
+
+	phonebook := NewPhonebook(GetCountryCode(tc))
+	for _, id := range strings.Split(result["NewPhonebookList"], ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+
+		phonebookResult, errPhonebook := tc.Call(ServiceOnTel, "GetPhonebook", map[string]string{"NewPhonebookID": id})
+		if errPhonebook != nil {
+			return Phonebook{}, errPhonebook
+		}
+
+		var pb phonebooks
+		if errList := tc.GetXML(phonebookResult["NewPhonebookURL"], &pb); errList != nil {
+			return Phonebook{}, errList
+		}
+		phonebook.add(pb)
+	}
+
+	return phonebook, nil
+}
+
+func (p Phonebook) add(pb phonebooks) {
+	for _, book := range pb.Phonebooks {
+		for _, c := range book.Contacts {
+			for _, number := range c.Numbers {
+				normalized := normalizeNumber(number, p.countryCode)
+				if _, exists := p.names[normalized]; normalized != "" && !exists {
+					p.names[normalized] = c.RealName
+				}
+			}
+		}
+	}
+}
+
+func (p Phonebook) Lookup(number string) string {
+	return p.names[normalizeNumber(number, p.countryCode)]
+}
+
+func ResolveCallNames(calls []Call, phonebook Phonebook) {
+	for i := range calls {
+		if calls[i].Name == "" {
+			calls[i].Name = phonebook.Lookup(calls[i].Number)
+		}
+	}
+}
+
+func toCalls(cl callList) []Call {
+	var calls []Call
+	for _, item := range cl.Calls {
+		callTime, _ := time.ParseInLocation("02.01.06 15:04", item.Date, time.Local)
+		call := Call{
+			ID:       item.Id,
+			Name:     item.Name,
+			Device:   item.Device,
+			Time:     callTime,
+			Duration: item.Duration,
+		}
+
+		switch item.Type {
+		case 1:
+			call.Type = CallIncoming
+		case 2:
+			call.Type = CallMissed
+		case 3:
+			call.Type = CallOutgoing
+		case 10:
+			call.Type = CallRejected
+		default:
+			call.Type = CallActive
+		}
+
+		if call.Type == CallOutgoing {
+			call.Number = item.Called
+			call.Own = item.CallerNumber
+		} else {
+			call.Number = item.Caller
+			call.Own = item.CalledNumber
+		}
+
+		calls = append(calls, call)
+	}
+	return calls
+}
+
+// Without the country code international numbers, e.g. +49 30 1234567, only match in the same format
+func GetCountryCode(tc TR064Client) string {
+	result, err := tc.Call(ServiceVoIP, "X_AVM-DE_GetVoIPCommonCountryCode", nil)
+	if err != nil {
+		log.Warn("Could not read the country code: %s", err)
+		return ""
+	}
+	return strings.TrimLeft(result["NewX_AVM-DE_LKZ"], "+0")
+}
+
+// Keeps only digits, +<country code> and 00<country code> of the own country become the national 0
+func normalizeNumber(number string, countryCode string) string {
+	number = strings.ReplaceAll(strings.TrimSpace(number), "(0)", "")
+	var builder strings.Builder
+	if strings.HasPrefix(number, "+") {
+		builder.WriteString("00")
+	}
+	for _, r := range number {
+		if r >= '0' && r <= '9' {
+			builder.WriteRune(r)
+		}
+	}
+
+	normalized := builder.String()
+	if national, found := strings.CutPrefix(normalized, "00"+countryCode); found && countryCode != "" {
+		return "0" + national
+	}
+	return normalized
+}
+
+func CountCalls(calls []Call, callType CallType) int {
+	count := 0
+	for _, call := range calls {
+		if call.Type == callType {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,65 @@
+package fritzbox
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func Test_toCalls(t *testing.T) {
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<root>
+<timestamp>1752247238</timestamp>
+<Call><Id>12</Id><Type>2</Type><Caller>0301234567</Caller><Called>SIP: 987654</Called><CalledNumber>987654</CalledNumber><Name></Name><Device></Device><Date>09.07.25 18:12</Date><Duration>0:00</Duration></Call>
+<Call><Id>11</Id><Type>3</Type><Called>0307654321</Called><CallerNumber>987654</CallerNumber><Name>Jamie</Name><Device>Kitchen</Device><Date>08.07.25 09:30</Date><Duration>0:12</Duration></Call>
+</root>`
+
+	var cl callList
+	if err := xml.Unmarshal([]byte(body), &cl); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := toCalls(cl)
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 calls, got %d", len(calls))
+	}
+
+	if calls[0].Type != CallMissed || calls[0].Number != "0301234567" || calls[0].Own != "987654" {
+		t.Errorf("invalid missed call %+v", calls[0])
+	}
+
+	if calls[1].Type != CallOutgoing || calls[1].Number != "0307654321" || calls[1].Time.Hour() != 9 {
+		t.Errorf("invalid outgoing call %+v", calls[1])
+	}
+
+	if CountCalls(calls, CallMissed) != 1 {
+		t.Error("invalid missed call count")
+	}
+}
+
+func Test_Phonebook(t *testing.T) {
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<phonebooks>
+<phonebook name="Telefonbuch">
+<contact><category>0</category><person><realName>Alex</realName></person><telephony><number type="home" prio="1">030 123 45-67</number><number type="mobile">0170 1234567</number></telephony></contact>
+<contact><category>0</category><person><realName>Sam</realName></person><telephony><number type="home">+49 30 7654321</number><number type="work">0049 (0)89 1234567</number><number type="mobile">+43 1 1234567</number></telephony></contact>
+</phonebook>
+</phonebooks>`
+
+	var pb phonebooks
+	if err := xml.Unmarshal([]byte(body), &pb); err != nil {
+		t.Fatal(err)
+	}
+
+	phonebook := NewPhonebook("49")
+	phonebook.add(pb)
+
+	calls := []Call{{Number: "0301234567"}, {Number: "01701234567"}, {Number: "0309999999"}, {Number: "0307654321"}, {Number: "0891234567"}, {Number: "004311234567"}, {Number: "011234567"}}
+	ResolveCallNames(calls, phonebook)
+
+	expected := []string{"Alex", "Alex", "", "Sam", "Sam", "Sam", ""}
+	for i, call := range calls {
+		if call.Name != expected[i] {
+			t.Errorf("expected %q for %s, got %q", expected[i], call.Number, call.Name)
+		}
+	}
+}
@@ -5,12 +5,16 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
 	"time"
 )
 
+// The FRITZ!Box refuses the SID, e.g. after a reboot or when the session expired
+var ErrSessionInvalid = errors.New("session is not valid")
+
 type Session interface {
 	GetSID() string
 	IsValid() bool
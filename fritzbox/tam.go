@@ -0,0 +1,114 @@
+package fritzbox
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+)
+
+const ServiceTAM = "urn:dslforum-org:service:X_AVM-DE_TAM:1"
+
+type TAM struct {
+	Index       int    `json:"index"`
+	Name        string `json:"name"`
+	Enabled     bool   `json:"enabled"`
+	NewMessages int    `json:"newMessages"`
+	Messages    int    `json:"messages"`
+}
+
+type TAMMessage struct {
+	Index    int    `json:"index" xml:"Index"`
+	TAM      int    `json:"tam" xml:"Tam"`
+	Name     string `json:"name,omitempty" xml:"Name"`
+	Number   string `json:"number" xml:"Number"`
+	Called   string `json:"called" xml:"Called"`
+	Date     string `json:"date" xml:"Date"`
+	Duration string `json:"duration" xml:"Duration"`
+	New      bool   `json:"new" xml:"New"`
+}
+
+type tamList struct {
+	XMLName xml.Name  `xml:"List"`
+	Items   []tamItem `xml:"Item"`
+}
+
+type tamItem struct {
+	Index   int    `xml:"Index"`
+	Display bool   `xml:"Display"`
+	Enable  bool   `xml:"Enable"`
+	Name    string `xml:"Name"`
+}
+
+type tamMessageList struct {
+	XMLName  xml.Name     `xml:"Root"`
+	Messages []TAMMessage `xml:"Message"`
+}
+
+func GetTAMs(tc TR064Client) ([]TAM, map[int][]TAMMessage, error) {
+	result, err := tc.Call(ServiceTAM, "GetList", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var tl tamList
+	if errList := xml.Unmarshal([]byte(result["NewTAMList"]), &tl); errList != nil {
+		return nil, nil, errList
+	}
+
+	var tams []TAM
+	indexToMessages := map[int][]TAMMessage{}
+	for _, item := range tl.Items {
+		if !item.Display {
+			continue
+		}
+
+		messages, errMessages := getTAMMessages(tc, item.Index)
+		if errMessages != nil {
+			return nil, nil, errMessages
+		}
+		indexToMessages[item.Index] = messages
+
+		tam := TAM{
+			Index:    item.Index,
+			Name:     item.Name,
+			Enabled:  item.Enable,
+			Messages: len(messages),
+		}
+		for _, message := range messages {
+			if message.New {
+				tam.NewMessages++
+			}
+		}
+		tams = append(tams, tam)
+	}
+
+	return tams, indexToMessages, nil
+}
+
+func SetTAMEnabled(tc TR064Client, index int, enabled bool) error {
+	value := "0"
+	if enabled {
+		value = "1"
+	}
+	_, err := tc.Call(ServiceTAM, "SetEnable", map[string]string{"NewIndex": strconv.Itoa(index), "NewEnable": value})
+	return err
+}
+
+func getTAMMessages(tc TR064Client, index int) ([]TAMMessage, error) {
+	result, err := tc.Call(ServiceTAM, "GetMessageList", map[string]string{"NewIndex": strconv.Itoa(index)})
+	if err != nil {
+		return nil, err
+	}
+
+	messageListURL := strings.TrimSpace(result["NewURL"])
+	if messageListURL == "" {
+		return nil, nil
+	}
+
+	var ml tamMessageList
+	if errMessages := tc.GetXML(messageListURL, &ml); errMessages != nil {
+		return nil, errMessages
+	}
+
+	return ml.Messages, nil
+}
@@ -0,0 +1,27 @@
+package fritzbox
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func Test_tamMessageList(t *testing.T) {
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<Root>
+<Message><Index>3</Index><Tam>0</Tam><Called>987654</Called><Date>09.07.25 18:12</Date><Duration>0:01</Duration><Inbook>1</Inbook><Name>Jamie</Name><New>1</New><Number>0301234567</Number><Path>/download.lua?path=/data/tam/rec/rec.0.003</Path></Message>
+<Message><Index>2</Index><Tam>0</Tam><Called>987654</Called><Date>08.07.25 09:30</Date><Duration>0:12</Duration><Inbook>0</Inbook><Name></Name><New>0</New><Number>0307654321</Number><Path>/download.lua?path=/data/tam/rec/rec.0.002</Path></Message>
+</Root>`
+
+	var ml tamMessageList
+	if err := xml.Unmarshal([]byte(body), &ml); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ml.Messages) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(ml.Messages))
+	}
+
+	if !ml.Messages[0].New || ml.Messages[0].Index != 3 || ml.Messages[0].Name != "Jamie" || ml.Messages[1].New {
+		t.Errorf("invalid messages %+v", ml.Messages)
+	}
+}
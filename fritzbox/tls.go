@@ -0,0 +1,187 @@
+package fritzbox
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+type TLSOptions struct {
+	Insecure        bool
+	CAFile          string
+	Fingerprint     string
+	FingerprintFile string
+}
+
+func (o TLSOptions) isSet() bool {
+	return o.Insecure || o.CAFile != "" || o.Fingerprint != "" || o.FingerprintFile != ""
+}
+
+func newHTTPClient(baseURL string, options TLSOptions) (*http.Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "http":
+		if options.isSet() {
+			return nil, fmt.Errorf("TLS options require an https base url, got %s", baseURL)
+		}
+		log.Warn("Using plain HTTP for %s, traffic to the FRITZ!Box is not encrypted", baseURL)
+		return &http.Client{}, nil
+	case "https":
+		tlsConfig, errConfig := newTLSConfig(options)
+		if errConfig != nil {
+			return nil, errConfig
+		}
+		return &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: tlsConfig,
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q in base url %s", u.Scheme, baseURL)
+	}
+}
+
+func newTLSConfig(options TLSOptions) (*tls.Config, error) {
+	if options.Insecure {
+		if options.CAFile != "" || options.Fingerprint != "" || options.FingerprintFile != "" {
+			return nil, fmt.Errorf("insecure mode can not be combined with other TLS options")
+		}
+		log.Warn("TLS certificate verification is disabled")
+		return &tls.Config{
+			InsecureSkipVerify: true,
+		}, nil
+	}
+
+	if options.Fingerprint != "" || options.FingerprintFile != "" {
+		if options.CAFile != "" {
+			return nil, fmt.Errorf("certificate pinning can not be combined with a CA file")
+		}
+		p, err := newPinning(options.Fingerprint, options.FingerprintFile)
+		if err != nil {
+			return nil, err
+		}
+		// Pinning replaces the chain verification, the self-signed FRITZ!Box certificate has no usable CA
+		return &tls.Config{
+			InsecureSkipVerify: true,
+			VerifyConnection:   p.verify,
+		}, nil
+	}
+
+	if options.CAFile != "" {
+		pem, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", options.CAFile)
+		}
+		return &tls.Config{
+			RootCAs: pool,
+		}, nil
+	}
+
+	return &tls.Config{}, nil
+}
+
+type pinning struct {
+	mutex       sync.Mutex
+	fingerprint string
+	file        string
+}
+
+func newPinning(fingerprint string, file string) (*pinning, error) {
+	p := &pinning{
+		file: file,
+	}
+
+	if fingerprint != "" {
+		normalized, err := normalizeFingerprint(fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		p.fingerprint = normalized
+		return p, nil
+	}
+
+	content, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Info("No pinned certificate in %s yet, trusting the first certificate presented", file)
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	normalized, err := normalizeFingerprint(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("invalid fingerprint in %s: %w", file, err)
+	}
+	p.fingerprint = normalized
+
+	return p, nil
+}
+
+func (p *pinning) verify(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("no peer certificate presented")
+	}
+
+	sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+	actual := hex.EncodeToString(sum[:])
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.fingerprint == "" {
+		if err := os.WriteFile(p.file, []byte(formatFingerprint(actual)+"\n"), 0600); err != nil {
+			return err
+		}
+		p.fingerprint = actual
+		log.Info("Pinned certificate with fingerprint %s to %s", formatFingerprint(actual), p.file)
+		return nil
+	}
+
+	if p.fingerprint != actual {
+		return fmt.Errorf("certificate fingerprint mismatch, expected %s, got %s", formatFingerprint(p.fingerprint), formatFingerprint(actual))
+	}
+
+	return nil
+}
+
+func normalizeFingerprint(fingerprint string) (string, error) {
+	normalized := strings.ToLower(strings.TrimSpace(fingerprint))
+	normalized = strings.TrimPrefix(normalized, "sha256:")
+	normalized = strings.ReplaceAll(normalized, ":", "")
+
+	decoded, err := hex.DecodeString(normalized)
+	if err != nil {
+		return "", err
+	}
+	if len(decoded) != sha256.Size {
+		return "", fmt.Errorf("expected a SHA-256 fingerprint with %d bytes, got %d", sha256.Size, len(decoded))
+	}
+
+	return normalized, nil
+}
+
+func formatFingerprint(fingerprint string) string {
+	var parts []string
+	for i := 0; i+2 <= len(fingerprint); i += 2 {
+		parts = append(parts, strings.ToUpper(fingerprint[i:i+2]))
+	}
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,63 @@
+package fritzbox
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_normalizeFingerprint(t *testing.T) {
+	expected := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+	normalized, err := normalizeFingerprint("9F:86:D0:81:88:4C:7D:65:9A:2F:EA:A0:C5:5A:D0:15:A3:BF:4F:1B:2B:0B:82:2C:D1:5D:6C:15:B0:F0:0A:08")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if normalized != expected {
+		t.Errorf("unexpected fingerprint %s", normalized)
+	}
+
+	if formatFingerprint(normalized)[:8] != "9F:86:D0" {
+		t.Errorf("unexpected formatted fingerprint %s", formatFingerprint(normalized))
+	}
+
+	if _, err := normalizeFingerprint("9F:86"); err == nil {
+		t.Error("short fingerprint accepted")
+	}
+}
+
+func Test_pinningTrustOnFirstUse(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "fingerprint")
+
+	p, err := newPinning("", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Raw: []byte("first")}}}
+	second := tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Raw: []byte("second")}}}
+
+	if err := p.verify(first); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := os.Stat(file); err != nil {
+		t.Error("fingerprint was not written")
+	}
+
+	reloaded, err := newPinning("", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reloaded.verify(first); err != nil {
+		t.Error(err)
+	}
+
+	if err := reloaded.verify(second); err == nil {
+		t.Error("changed certificate accepted")
+	}
+}
@@ -0,0 +1,429 @@
+package fritzbox
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/log"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+type TR064Client interface {
+	Services() ([]TR064Service, error)
+	Call(serviceType string, action string, arguments map[string]string) (map[string]string, error)
+	GetXML(path string, v any) error
+	Reset()
+}
+
+type TR064Service struct {
+	ServiceType string
+	ServiceID   string
+	ControlURL  string
+	SCPDURL     string
+	Actions     []TR064Action
+}
+
+type TR064Action struct {
+	Name string
+	In   []string
+	Out  []string
+}
+
+type TR064Error struct {
+	Code        int
+	Description string
+}
+
+func (e *TR064Error) Error() string {
+	return fmt.Sprintf("TR-064 error %d: %s", e.Code, e.Description)
+}
+
+// UPnP fault of actions the firmware does not know
+const errorInvalidAction = 401
+
+func isInvalidAction(err error) bool {
+	var fault *TR064Error
+	return errors.As(err, &fault) && fault.Code == errorInvalidAction
+}
+
+type tr064Client struct {
+	baseURL  string
+	username string
+	password string
+	client   *http.Client
+	digest   *digestAuth
+	mutex    sync.Mutex
+	services []TR064Service
+}
+
+type tr064Description struct {
+	XMLName xml.Name    `xml:"root"`
+	Device  tr064Device `xml:"device"`
+}
+
+type tr064Device struct {
+	DeviceType   string         `xml:"deviceType"`
+	FriendlyName string         `xml:"friendlyName"`
+	ModelName    string         `xml:"modelName"`
+	Services     []tr064Service `xml:"serviceList>service"`
+	Devices      []tr064Device  `xml:"deviceList>device"`
+}
+
+type tr064Service struct {
+	ServiceType string `xml:"serviceType"`
+	ServiceID   string `xml:"serviceId"`
+	ControlURL  string `xml:"controlURL"`
+	SCPDURL     string `xml:"SCPDURL"`
+}
+
+type tr064SCPD struct {
+	XMLName xml.Name      `xml:"scpd"`
+	Actions []tr064Action `xml:"actionList>action"`
+}
+
+type tr064Action struct {
+	Name      string          `xml:"name"`
+	Arguments []tr064Argument `xml:"argumentList>argument"`
+}
+
+type tr064Argument struct {
+	Name      string `xml:"name"`
+	Direction string `xml:"direction"`
+}
+
+func NewTR064Client(baseURL string, tlsOptions TLSOptions, username string, password string) (TR064Client, error) {
+	tr064URL, err := tr064BaseURL(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient, err := newHTTPClient(baseURL, tlsOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tr064Client{
+		baseURL:  tr064URL,
+		username: username,
+		password: password,
+		client:   httpClient,
+		digest:   &digestAuth{},
+	}, nil
+}
+
+// TR-064 is served on its own ports, 49000 for plain HTTP and 49443 for HTTPS
+func tr064BaseURL(baseURL string) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+
+	port := "49443"
+	if u.Scheme == "http" {
+		port = "49000"
+	}
+
+	return fmt.Sprintf("%s://%s:%s", u.Scheme, u.Hostname(), port), nil
+}
+
+func (tc *tr064Client) Services() ([]TR064Service, error) {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	if tc.services != nil {
+		return tc.services, nil
+	}
+
+	var description tr064Description
+	if err := tc.getXML("/tr64desc.xml", &description); err != nil {
+		return nil, err
+	}
+
+	var services []TR064Service
+	for _, s := range collectServices(description.Device) {
+		var scpd tr064SCPD
+		if err := tc.getXML(s.SCPDURL, &scpd); err != nil {
+			return nil, err
+		}
+
+		service := TR064Service{
+			ServiceType: s.ServiceType,
+			ServiceID:   s.ServiceID,
+			ControlURL:  s.ControlURL,
+			SCPDURL:     s.SCPDURL,
+		}
+		for _, a := range scpd.Actions {
+			action := TR064Action{Name: a.Name}
+			for _, argument := range a.Arguments {
+				if argument.Direction == "in" {
+					action.In = append(action.In, argument.Name)
+				} else {
+					action.Out = append(action.Out, argument.Name)
+				}
+			}
+			service.Actions = append(service.Actions, action)
+		}
+		services = append(services, service)
+	}
+
+	log.Debug("Discovered %d TR-064 services for %s (%s)", len(services), description.Device.FriendlyName, description.Device.ModelName)
+
+	tc.services = services
+
+	return services, nil
+}
+
+func collectServices(d tr064Device) []tr064Service {
+	services := append([]tr064Service{}, d.Services...)
+	for _, child := range d.Devices {
+		services = append(services, collectServices(child)...)
+	}
+	return services
+}
+
+// Forgets discovered services and the digest challenge, e.g. after the FRITZ!Box rebooted
+func (tc *tr064Client) Reset() {
+	tc.mutex.Lock()
+	tc.services = nil
+	tc.mutex.Unlock()
+
+	tc.digest.reset()
+}
+
+func (tc *tr064Client) Call(serviceType string, action string, arguments map[string]string) (map[string]string, error) {
+	services, err := tc.Services()
+	if err != nil {
+		return nil, err
+	}
+
+	service, serviceAction, err := findAction(services, serviceType, action)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := soapEnvelope(serviceType, serviceAction, arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := tc.do(http.MethodPost, service.ControlURL, body, func(req *http.Request) {
+		req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+		req.Header.Set("SOAPAction", fmt.Sprintf("%s#%s", serviceType, action))
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result, err := parseSOAPResponse(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("TR-064 call %s#%s failed with status %s", serviceType, action, resp.Status)
+	}
+
+	return result, nil
+}
+
+func findAction(services []TR064Service, serviceType string, action string) (TR064Service, TR064Action, error) {
+	for _, s := range services {
+		if s.ServiceType != serviceType {
+			continue
+		}
+		for _, a := range s.Actions {
+			if a.Name == action {
+				return s, a, nil
+			}
+		}
+		// Answered like the FRITZ!Box does, without asking it
+		return TR064Service{}, TR064Action{}, &TR064Error{Code: errorInvalidAction, Description: fmt.Sprintf("Invalid Action %s of service %s", action, serviceType)}
+	}
+	return TR064Service{}, TR064Action{}, fmt.Errorf("service %s not available", serviceType)
+}
+
+func soapEnvelope(serviceType string, action TR064Action, arguments map[string]string) ([]byte, error) {
+	for name := range arguments {
+		known := false
+		for _, in := range action.In {
+			known = known || in == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown argument %s for action %s", name, action.Name)
+		}
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	buffer.WriteString(`<s:Envelope s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/" xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>`)
+	fmt.Fprintf(&buffer, `<u:%s xmlns:u="%s">`, action.Name, serviceType)
+	// Arguments are sent in the order of the service description
+	for _, name := range action.In {
+		value, exists := arguments[name]
+		if !exists {
+			continue
+		}
+		fmt.Fprintf(&buffer, "<%s>", name)
+		if err := xml.EscapeText(&buffer, []byte(value)); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buffer, "</%s>", name)
+	}
+	fmt.Fprintf(&buffer, "</u:%s>", action.Name)
+	buffer.WriteString(`</s:Body></s:Envelope>`)
+
+	return buffer.Bytes(), nil
+}
+
+func parseSOAPResponse(body io.Reader) (map[string]string, error) {
+	decoder := xml.NewDecoder(body)
+	result := map[string]string{}
+	depth := 0
+	bodyDepth := -1
+	var current string
+	var fault *TR064Error
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if t.Name.Local == "Body" && bodyDepth < 0 {
+				bodyDepth = depth
+			}
+			if t.Name.Local == "Fault" && depth == bodyDepth+1 {
+				fault = &TR064Error{}
+			}
+			if fault != nil {
+				current = t.Name.Local
+			} else if bodyDepth > 0 && depth == bodyDepth+2 {
+				current = t.Name.Local
+				result[current] = ""
+			}
+		case xml.CharData:
+			if current == "" {
+				continue
+			}
+			value := string(t)
+			if fault != nil {
+				switch current {
+				case "errorCode":
+					code, errCode := parseInt(strings.TrimSpace(value))
+					if errCode != nil {
+						return nil, errCode
+					}
+					fault.Code = code
+				case "errorDescription":
+					fault.Description = value
+				}
+				continue
+			}
+			result[current] += value
+		case xml.EndElement:
+			depth--
+			current = ""
+		}
+	}
+
+	if fault != nil {
+		return nil, fault
+	}
+
+	if bodyDepth < 0 {
+		return nil, fmt.Errorf("no SOAP body in TR-064 response")
+	}
+
+	return result, nil
+}
+
+func (tc *tr064Client) GetXML(path string, v any) error {
+	return tc.getXML(path, v)
+}
+
+func (tc *tr064Client) getXML(path string, v any) error {
+	resp, err := tc.do(http.MethodGet, path, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("TR-064 request %s failed with status %s", path, resp.Status)
+	}
+
+	return xml.NewDecoder(resp.Body).Decode(v)
+}
+
+func (tc *tr064Client) do(method string, path string, body []byte, prepare func(req *http.Request)) (*http.Response, error) {
+	// Lists like the host or message list are referenced with absolute URLs
+	requestURL := path
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		requestURL = tc.baseURL + path
+	}
+
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequest(method, requestURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if prepare != nil {
+			prepare(req)
+		}
+		return req, nil
+	}
+
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+	tc.digest.authorize(req, tc.username, tc.password)
+
+	resp, err := tc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	// The nonce is missing or stale, take the new challenge and retry once
+	challengeHeader := resp.Header.Get("WWW-Authenticate")
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if errChallenge := tc.digest.update(challengeHeader); errChallenge != nil {
+		return nil, errChallenge
+	}
+
+	req, err = newRequest()
+	if err != nil {
+		return nil, err
+	}
+	tc.digest.authorize(req, tc.username, tc.password)
+
+	resp, err = tc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, fmt.Errorf("TR-064 authentication failed for user=%s", tc.username)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,107 @@
+package fritzbox
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_digestResponse(t *testing.T) {
+	// Example from RFC 2617, section 3.5
+	response := digestResponse("Mufasa", "Circle Of Life", "testrealm@host.com", "dcd98b7102dd2f0e8b11d0f600bfb0c093", "GET", "/dir/index.html", "auth", "00000001", "0a4f113b")
+
+	if response != "6629fae49393a05397450978507c4ef1" {
+		t.Errorf("invalid response %s", response)
+	}
+}
+
+func Test_parseDigestParameters(t *testing.T) {
+	parameters := parseDigestParameters(`realm="HTTPS Access", nonce="2F1C4E0A5D3B7A91", algorithm=MD5, qop="auth"`)
+
+	if parameters["realm"] != "HTTPS Access" {
+		t.Errorf("invalid realm %s", parameters["realm"])
+	}
+	if parameters["nonce"] != "2F1C4E0A5D3B7A91" {
+		t.Errorf("invalid nonce %s", parameters["nonce"])
+	}
+	if parameters["algorithm"] != "MD5" {
+		t.Errorf("invalid algorithm %s", parameters["algorithm"])
+	}
+	if parameters["qop"] != "auth" {
+		t.Errorf("invalid qop %s", parameters["qop"])
+	}
+}
+
+func Test_parseSOAPResponse(t *testing.T) {
+	body := `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body>
+<u:GetInfoResponse xmlns:u="urn:dslforum-org:service:DeviceInfo:1">
+<NewModelName>FRITZ!Box 7590</NewModelName>
+<NewSoftwareVersion>154.07.57</NewSoftwareVersion>
+<NewDescription></NewDescription>
+</u:GetInfoResponse>
+</s:Body>
+</s:Envelope>`
+
+	result, err := parseSOAPResponse(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result["NewModelName"] != "FRITZ!Box 7590" {
+		t.Errorf("invalid model name %s", result["NewModelName"])
+	}
+	if result["NewSoftwareVersion"] != "154.07.57" {
+		t.Errorf("invalid software version %s", result["NewSoftwareVersion"])
+	}
+	if _, exists := result["NewDescription"]; !exists {
+		t.Error("empty argument missing")
+	}
+}
+
+func Test_parseSOAPFault(t *testing.T) {
+	body := `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">
+<s:Body>
+<s:Fault>
+<faultcode>s:Client</faultcode>
+<faultstring>UPnPError</faultstring>
+<detail>
+<UPnPError xmlns="urn:dslforum-org:control-1-0">
+<errorCode>714</errorCode>
+<errorDescription>NoSuchEntryInArray</errorDescription>
+</UPnPError>
+</detail>
+</s:Fault>
+</s:Body>
+</s:Envelope>`
+
+	_, err := parseSOAPResponse(strings.NewReader(body))
+
+	var tr064Error *TR064Error
+	if !errors.As(err, &tr064Error) {
+		t.Fatalf("expected TR-064 error, got %v", err)
+	}
+
+	if tr064Error.Code != 714 || tr064Error.Description != "NoSuchEntryInArray" {
+		t.Errorf("invalid error %v", tr064Error)
+	}
+}
+
+func Test_soapEnvelope(t *testing.T) {
+	action := TR064Action{Name: "GetSpecificHostEntry", In: []string{"NewMACAddress"}}
+
+	body, err := soapEnvelope("urn:dslforum-org:service:Hosts:1", action, map[string]string{"NewMACAddress": "AA:BB:CC:DD:EE:FF"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(body), `<u:GetSpecificHostEntry xmlns:u="urn:dslforum-org:service:Hosts:1"><NewMACAddress>AA:BB:CC:DD:EE:FF</NewMACAddress></u:GetSpecificHostEntry>`) {
+		t.Errorf("invalid envelope %s", body)
+	}
+
+	if _, err := soapEnvelope("urn:dslforum-org:service:Hosts:1", action, map[string]string{"NewIPAddress": "192.168.178.2"}); err == nil {
+		t.Error("unknown argument accepted")
+	}
+}
@@ -0,0 +1,26 @@
+package fritzbox
+
+import "fmt"
+
+const ServiceVoIP = "urn:dslforum-org:service:X_VoIP:1"
+
+// Dialing requires click-to-dial to be enabled on the FRITZ!Box, an empty phone keeps the configured one
+func DialNumber(tc TR064Client, number string, phone string) error {
+	if number == "" {
+		return fmt.Errorf("no number to dial")
+	}
+
+	if phone != "" {
+		if _, err := tc.Call(ServiceVoIP, "X_AVM-DE_DialSetConfig", map[string]string{"NewX_AVM-DE_PhoneName": phone}); err != nil {
+			return err
+		}
+	}
+
+	_, err := tc.Call(ServiceVoIP, "X_AVM-DE_DialNumber", map[string]string{"NewX_AVM-DE_PhoneNumber": number})
+	return err
+}
+
+func DialHangup(tc TR064Client) error {
+	_, err := tc.Call(ServiceVoIP, "X_AVM-DE_DialHangup", nil)
+	return err
+}
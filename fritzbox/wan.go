@@ -0,0 +1,98 @@
+package fritzbox
+
+import (
+	"strconv"
+)
+
+const (
+	ServiceWANCommonInterfaceConfig = "urn:dslforum-org:service:WANCommonInterfaceConfig:1"
+	ServiceWANIPConnection          = "urn:dslforum-org:service:WANIPConnection:1"
+	ServiceWANPPPConnection         = "urn:dslforum-org:service:WANPPPConnection:1"
+	ServiceWANDSLInterfaceConfig    = "urn:dslforum-org:service:WANDSLInterfaceConfig:1"
+)
+
+type WANStatus struct {
+	AccessType           string `json:"accessType"`
+	PhysicalLinkStatus   string `json:"physicalLinkStatus"`
+	ConnectionStatus     string `json:"connectionStatus"`
+	Connected            bool   `json:"connected"`
+	ExternalIPv4         string `json:"externalIPv4,omitempty"`
+	ExternalIPv6         string `json:"externalIPv6,omitempty"`
+	Uptime               uint64 `json:"uptime"`                       // seconds
+	UpstreamMaxBitRate   uint64 `json:"upstreamMaxBitRate"`           // bit/s
+	DownstreamMaxBitRate uint64 `json:"downstreamMaxBitRate"`         // bit/s
+	UpstreamSyncRate     uint64 `json:"upstreamSyncRate,omitempty"`   // kbit/s, DSL only
+	DownstreamSyncRate   uint64 `json:"downstreamSyncRate,omitempty"` // kbit/s, DSL only
+	BytesSent            uint64 `json:"bytesSent"`
+	BytesReceived        uint64 `json:"bytesReceived"`
+	ByteSendRate         uint64 `json:"byteSendRate"`    // bytes/s
+	ByteReceiveRate      uint64 `json:"byteReceiveRate"` // bytes/s
+}
+
+func GetWANStatus(tc TR064Client) (WANStatus, error) {
+	link, err := tc.Call(ServiceWANCommonInterfaceConfig, "GetCommonLinkProperties", nil)
+	if err != nil {
+		return WANStatus{}, err
+	}
+
+	addon, err := tc.Call(ServiceWANCommonInterfaceConfig, "GetAddonInfos", nil)
+	if err != nil {
+		return WANStatus{}, err
+	}
+
+	// DSL lines with PPPoE report the connection on WANPPPConnection instead of WANIPConnection
+	connectionService := ServiceWANIPConnection
+	connection, err := tc.Call(connectionService, "GetInfo", nil)
+	if err != nil || connection["NewConnectionStatus"] != "Connected" {
+		pppConnection, errPPP := tc.Call(ServiceWANPPPConnection, "GetInfo", nil)
+		if errPPP == nil {
+			connectionService = ServiceWANPPPConnection
+			connection, err = pppConnection, nil
+		}
+	}
+	if err != nil {
+		return WANStatus{}, err
+	}
+
+	status := newWANStatus(link, addon, connection)
+
+	if ipv6, errIPv6 := tc.Call(connectionService, "X_AVM_DE_GetExternalIPv6Address", nil); errIPv6 == nil {
+		status.ExternalIPv6 = ipv6["NewExternalIPv6Address"]
+	}
+
+	if status.AccessType == "DSL" {
+		dsl, errDSL := tc.Call(ServiceWANDSLInterfaceConfig, "GetInfo", nil)
+		if errDSL != nil {
+			return WANStatus{}, errDSL
+		}
+		status.UpstreamSyncRate = parseUint(dsl["NewUpstreamCurrRate"])
+		status.DownstreamSyncRate = parseUint(dsl["NewDownstreamCurrRate"])
+	}
+
+	return status, nil
+}
+
+func newWANStatus(link map[string]string, addon map[string]string, connection map[string]string) WANStatus {
+	return WANStatus{
+		AccessType:           link["NewWANAccessType"],
+		PhysicalLinkStatus:   link["NewPhysicalLinkStatus"],
+		ConnectionStatus:     connection["NewConnectionStatus"],
+		Connected:            connection["NewConnectionStatus"] == "Connected",
+		ExternalIPv4:         connection["NewExternalIPAddress"],
+		Uptime:               parseUint(connection["NewUptime"]),
+		UpstreamMaxBitRate:   parseUint(link["NewLayer1UpstreamMaxBitRate"]),
+		DownstreamMaxBitRate: parseUint(link["NewLayer1DownstreamMaxBitRate"]),
+		BytesSent:            parseUint(addon["NewX_AVM_DE_TotalBytesSent64"]),
+		BytesReceived:        parseUint(addon["NewX_AVM_DE_TotalBytesReceived64"]),
+		ByteSendRate:         parseUint(addon["NewByteSendRate"]),
+		ByteReceiveRate:      parseUint(addon["NewByteReceiveRate"]),
+	}
+}
+
+func parseUint(s string) uint64 {
+	value, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
@@ -0,0 +1,37 @@
+package fritzbox
+
+import "testing"
+
+func Test_newWANStatus(t *testing.T) {
+	link := map[string]string{
+		"NewWANAccessType":              "DSL",
+		"NewLayer1UpstreamMaxBitRate":   "40000000",
+		"NewLayer1DownstreamMaxBitRate": "250000000",
+		"NewPhysicalLinkStatus":         "Up",
+	}
+	addon := map[string]string{
+		"NewByteSendRate":                  "1024",
+		"NewByteReceiveRate":               "4096",
+		"NewX_AVM_DE_TotalBytesSent64":     "5368709120",
+		"NewX_AVM_DE_TotalBytesReceived64": "53687091200",
+	}
+	connection := map[string]string{
+		"NewConnectionStatus":  "Connected",
+		"NewUptime":            "86400",
+		"NewExternalIPAddress": "203.0.113.7",
+	}
+
+	status := newWANStatus(link, addon, connection)
+
+	if !status.Connected || status.ExternalIPv4 != "203.0.113.7" || status.Uptime != 86400 {
+		t.Errorf("invalid connection %+v", status)
+	}
+
+	if status.DownstreamMaxBitRate != 250000000 || status.BytesSent != 5368709120 || status.ByteReceiveRate != 4096 {
+		t.Errorf("invalid rates %+v", status)
+	}
+
+	if parseUint("") != 0 {
+		t.Error("empty value not parsed as 0")
+	}
+}
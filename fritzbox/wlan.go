@@ -0,0 +1,112 @@
+package fritzbox
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const ServiceWLANConfigurationPrefix = "urn:dslforum-org:service:WLANConfiguration:"
+
+type WLAN struct {
+	Index   int    `json:"index"`
+	Name    string `json:"name"`
+	SSID    string `json:"ssid"`
+	Enabled bool   `json:"enabled"`
+	Status  string `json:"status"`
+	Channel int    `json:"channel"`
+	Band    string `json:"band,omitempty"`
+	Clients int    `json:"clients"`
+}
+
+func GetWLANs(tc TR064Client) ([]WLAN, error) {
+	indexes, err := wlanIndexes(tc)
+	if err != nil {
+		return nil, err
+	}
+
+	var wlans []WLAN
+	for i, index := range indexes {
+		serviceType := wlanServiceType(index)
+		info, errInfo := tc.Call(serviceType, "GetInfo", nil)
+		if errInfo != nil {
+			return nil, errInfo
+		}
+
+		associations, errAssociations := tc.Call(serviceType, "GetTotalAssociations", nil)
+		if errAssociations != nil {
+			return nil, errAssociations
+		}
+
+		isGuest := len(indexes) > 1 && i == len(indexes)-1
+		wlans = append(wlans, newWLAN(index, isGuest, info, associations))
+	}
+
+	return wlans, nil
+}
+
+func SetWLANEnabled(tc TR064Client, index int, enabled bool) error {
+	value := "0"
+	if enabled {
+		value = "1"
+	}
+	_, err := tc.Call(wlanServiceType(index), "SetEnable", map[string]string{"NewEnable": value})
+	return err
+}
+
+// The FRITZ!Box numbers its WLANConfiguration services by band, the guest network is always the last one
+func wlanIndexes(tc TR064Client) ([]int, error) {
+	services, err := tc.Services()
+	if err != nil {
+		return nil, err
+	}
+
+	var indexes []int
+	for _, service := range services {
+		if !strings.HasPrefix(service.ServiceType, ServiceWLANConfigurationPrefix) {
+			continue
+		}
+		index, errIndex := strconv.Atoi(strings.TrimPrefix(service.ServiceType, ServiceWLANConfigurationPrefix))
+		if errIndex != nil {
+			continue
+		}
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	return indexes, nil
+}
+
+func newWLAN(index int, isGuest bool, info map[string]string, associations map[string]string) WLAN {
+	channel, _ := strconv.Atoi(info["NewChannel"])
+	clients, _ := strconv.Atoi(associations["NewTotalAssociations"])
+	band := info["NewX_AVM-DE_FrequencyBand"]
+
+	name := fmt.Sprintf("wlan%d", index)
+	switch {
+	case isGuest:
+		name = "guest"
+	case band == "2400":
+		name = "2.4ghz"
+	case band == "5000":
+		name = "5ghz"
+	case band == "6000":
+		name = "6ghz"
+	}
+
+	return WLAN{
+		Index:   index,
+		Name:    name,
+		SSID:    info["NewSSID"],
+		Enabled: info["NewEnable"] == "1",
+		Status:  info["NewStatus"],
+		Channel: channel,
+		Band:    band,
+		Clients: clients,
+	}
+}
+
+func wlanServiceType(index int) string {
+	return fmt.Sprintf("%s%d", ServiceWLANConfigurationPrefix, index)
+}
@@ -0,0 +1,28 @@
+package fritzbox
+
+import "testing"
+
+func Test_newWLAN(t *testing.T) {
+	info := map[string]string{
+		"NewEnable":                 "1",
+		"NewStatus":                 "Up",
+		"NewSSID":                   "FRITZ!Box 7590",
+		"NewChannel":                "36",
+		"NewX_AVM-DE_FrequencyBand": "5000",
+	}
+
+	wlan := newWLAN(2, false, info, map[string]string{"NewTotalAssociations": "4"})
+	if wlan.Name != "5ghz" || !wlan.Enabled || wlan.Channel != 36 || wlan.Clients != 4 {
+		t.Errorf("invalid WLAN %+v", wlan)
+	}
+
+	guest := newWLAN(3, true, info, map[string]string{"NewTotalAssociations": "0"})
+	if guest.Name != "guest" {
+		t.Errorf("invalid guest WLAN %+v", guest)
+	}
+
+	unknown := newWLAN(1, false, map[string]string{}, map[string]string{})
+	if unknown.Name != "wlan1" || unknown.Enabled {
+		t.Errorf("invalid WLAN %+v", unknown)
+	}
+}
@@ -0,0 +1,56 @@
+package internal
+
+import "fmt"
+
+const (
+	Online  = "online"
+	Offline = "offline"
+)
+
+// Tracks whether the session with the FRITZ!Box is healthy, it is only meaningful while the bridge status is online
+type availability struct {
+	publishChan chan Message
+	topic       string
+	known       bool
+	online      bool
+}
+
+func newAvailability(publishChan chan Message, topicPrefix string) *availability {
+	return &availability{
+		publishChan: publishChan,
+		topic:       sessionTopic(topicPrefix),
+	}
+}
+
+func (a *availability) set(online bool) {
+	if a.known && a.online == online {
+		return
+	}
+	a.known = true
+	a.online = online
+	a.publishChan <- Message{
+		Topic:    a.topic,
+		Payload:  []byte(availabilityPayload(online)),
+		Retained: true,
+	}
+}
+
+// MQTT is stopped after the boxes, so this still reaches the broker on a clean shutdown
+func (a *availability) shutdown() {
+	a.publishChan <- Message{Topic: a.topic, Payload: []byte(Offline), Retained: true}
+}
+
+func StatusTopic(topicPrefix string) string {
+	return fmt.Sprintf("%s/bridge/status", topicPrefix)
+}
+
+func sessionTopic(topicPrefix string) string {
+	return fmt.Sprintf("%s/bridge/session", topicPrefix)
+}
+
+func availabilityPayload(online bool) string {
+	if online {
+		return Online
+	}
+	return Offline
+}
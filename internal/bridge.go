@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"github.com/webishdev/fritze-mqtt/log"
+)
+
+type bridgeDevice struct {
+	AIN          string            `json:"ain"`
+	DeviceAIN    string            `json:"deviceAin"`
+	Name         string            `json:"name"`
+	Product      string            `json:"product"`
+	Manufacturer string            `json:"manufacturer"`
+	Firmware     string            `json:"firmware"`
+	Description  string            `json:"description"`
+	Type         string            `json:"type"`
+	Present      bool              `json:"present"`
+	Values       map[string]string `json:"values"`
+}
+
+func BridgeCommands(topicPrefix string, commandChan chan DeviceCommand, publishChan chan Message) []Command {
+	return []Command{
+		{
+			Topic: fmt.Sprintf("%s/bridge/refresh", topicPrefix),
+			Handler: func(topic string, payload []byte) {
+				commandChan <- DeviceCommand{Refresh: true}
+			},
+		},
+		{
+			Topic: fmt.Sprintf("%s/bridge/devices", topicPrefix),
+			Handler: func(topic string, payload []byte) {
+				commandChan <- DeviceCommand{
+					Devices: func(devices []fritzbox.Device) {
+						publishDevices(publishChan, topicPrefix, devices)
+					},
+				}
+			},
+		},
+	}
+}
+
+func publishDevices(publishChan chan Message, topicPrefix string, devices []fritzbox.Device) {
+	payload, errMarshal := json.Marshal(newBridgeDevices(devices))
+	if errMarshal != nil {
+		log.Error("Could not marshal devices: %s", errMarshal)
+		return
+	}
+
+	publishChan <- Message{
+		Topic:   fmt.Sprintf("%s/bridge/devices/response", topicPrefix),
+		Payload: payload,
+		Class:   ClassEvent,
+	}
+}
+
+func newBridgeDevices(devices []fritzbox.Device) []bridgeDevice {
+	result := make([]bridgeDevice, 0, len(devices))
+	for _, device := range devices {
+		result = append(result, bridgeDevice{
+			AIN:          device.Identifier,
+			DeviceAIN:    device.DeviceIdentifier,
+			Name:         device.Name,
+			Product:      device.ProductName,
+			Manufacturer: device.Manufacturer,
+			Firmware:     device.FwVersion,
+			Description:  device.Description,
+			Type:         deviceType(device),
+			Present:      device.Present,
+			Values:       deviceValues(device),
+		})
+	}
+	return result
+}
@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"encoding/json"
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"testing"
+)
+
+func Test_publishDevices(t *testing.T) {
+	power := 1.52
+	devices := []fritzbox.Device{
+		{
+			ProductName:      "FRITZ!DECT 200",
+			Identifier:       "11657 0240192",
+			DeviceIdentifier: "11657 0240192",
+			Name:             "Washing machine",
+			StateValue:       1,
+			Present:          true,
+			Functions:        []fritzbox.DeviceFunction{fritzbox.AVMOutletSwitch},
+			Power:            &power,
+		},
+	}
+
+	publishChan := make(chan Message, 1)
+	publishDevices(publishChan, "fritze", devices)
+	message := <-publishChan
+
+	if message.Topic != "fritze/bridge/devices/response" || message.Class != ClassEvent {
+		t.Errorf("invalid message %+v", message)
+	}
+
+	var published []bridgeDevice
+	if err := json.Unmarshal(message.Payload, &published); err != nil {
+		t.Fatal(err)
+	}
+	if len(published) != 1 || published[0].AIN != "11657 0240192" || published[0].Type != "switch" || published[0].Values["power"] != "1.52" {
+		t.Errorf("invalid devices %+v", published)
+	}
+}
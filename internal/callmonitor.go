@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"github.com/webishdev/fritze-mqtt/log"
+	"net"
+	"strings"
+	"time"
+)
+
+func StartCallMonitor(callMonitorChan chan byte, address string, publishChan chan Message, topicPrefix string) error {
+	backoff := time.Second
+	for {
+		connected := time.Now()
+		errConnection := monitorCalls(callMonitorChan, address, publishChan, topicPrefix)
+		if errConnection == nil {
+			return nil
+		}
+
+		if time.Since(connected) > time.Minute {
+			backoff = time.Second
+		}
+		log.Warn("Call monitor connection to %s failed: %s, reconnecting in %s", address, errConnection, backoff)
+
+		select {
+		case <-callMonitorChan:
+			return nil
+		case <-time.After(backoff):
+		}
+
+		backoff = min(2*backoff, time.Minute)
+	}
+}
+
+func monitorCalls(callMonitorChan chan byte, address string, publishChan chan Message, topicPrefix string) error {
+	conn, errDial := net.DialTimeout("tcp", address, 10*time.Second)
+	if errDial != nil {
+		return errDial
+	}
+	defer conn.Close()
+
+	log.Info("Connected to call monitor at %s", address)
+
+	lineChan := make(chan string)
+	errChan := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			select {
+			case lineChan <- scanner.Text():
+			case <-done:
+				return
+			}
+		}
+		if errScan := scanner.Err(); errScan != nil {
+			errChan <- errScan
+		} else {
+			errChan <- fmt.Errorf("connection closed")
+		}
+	}()
+
+	for {
+		select {
+		case <-callMonitorChan:
+			log.Info("Disconnected from call monitor at %s", address)
+			return nil
+		case errRead := <-errChan:
+			return errRead
+		case line := <-lineChan:
+			event, errParse := fritzbox.ParseCallEvent(line)
+			if errParse != nil {
+				log.Warn("Ignoring call monitor line %q: %s", line, errParse)
+				continue
+			}
+			log.Info("Call monitor %s on connection %d", event.Type, event.ConnectionID)
+			publishCallEvent(publishChan, topicPrefix, event)
+		}
+	}
+}
+
+func publishCallEvent(publishChan chan Message, topicPrefix string, event fritzbox.CallEvent) {
+	payload, errMarshal := json.Marshal(event)
+	if errMarshal != nil {
+		log.Error("Could not marshal call event: %s", errMarshal)
+		return
+	}
+
+	publishChan <- Message{
+		Topic:   fmt.Sprintf("%s/callmonitor/%s", topicPrefix, strings.ToLower(string(event.Type))),
+		Payload: payload,
+		Expiry:  eventExpiry,
+		Class:   ClassEvent,
+	}
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"github.com/webishdev/fritze-mqtt/log"
+	"io"
+	"strconv"
+	"time"
+)
+
+func ExportCalls(tc fritzbox.TR064Client, days int, format string, w io.Writer) error {
+	calls, errCalls := getCalls(tc, days)
+	if errCalls != nil {
+		return errCalls
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(calls)
+	case "csv":
+		writer := csv.NewWriter(w)
+		if errHeader := writer.Write([]string{"id", "type", "time", "number", "name", "own", "device", "duration"}); errHeader != nil {
+			return errHeader
+		}
+		for _, call := range calls {
+			record := []string{strconv.Itoa(call.ID), string(call.Type), call.Time.Format(time.DateTime), call.Number, call.Name, call.Own, call.Device, call.Duration}
+			if errRecord := writer.Write(record); errRecord != nil {
+				return errRecord
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		return fmt.Errorf("unsupported call list format %s, use csv or json", format)
+	}
+}
+
+func StartCalls(callsChan chan byte, tc fritzbox.TR064Client, publishChan chan Message, topicPrefix string, interval time.Duration, days int) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		calls, errCalls := getCalls(tc, days)
+		if errCalls != nil {
+			log.Error("Could not read call list: %s", errCalls)
+		} else {
+			publishCalls(publishChan, topicPrefix, calls)
+		}
+
+		select {
+		case <-callsChan:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func getCalls(tc fritzbox.TR064Client, days int) ([]fritzbox.Call, error) {
+	calls, errCalls := fritzbox.GetCalls(tc, days)
+	if errCalls != nil {
+		return nil, errCalls
+	}
+
+	phonebook, errPhonebook := fritzbox.GetPhonebook(tc)
+	if errPhonebook != nil {
+		log.Warn("Could not read phonebooks: %s", errPhonebook)
+		return calls, nil
+	}
+	fritzbox.ResolveCallNames(calls, phonebook)
+
+	return calls, nil
+}
+
+func publishCalls(publishChan chan Message, topicPrefix string, calls []fritzbox.Call) {
+	publishChan <- Message{
+		Topic:    fmt.Sprintf("%s/calls/missed", topicPrefix),
+		Payload:  []byte(strconv.Itoa(fritzbox.CountCalls(calls, fritzbox.CallMissed))),
+		Retained: true,
+		Class:    ClassState,
+	}
+
+	if calls == nil {
+		calls = []fritzbox.Call{}
+	}
+
+	payload, errMarshal := json.Marshal(calls)
+	if errMarshal != nil {
+		log.Error("Could not marshal call list: %s", errMarshal)
+		return
+	}
+
+	publishChan <- Message{
+		Topic:    fmt.Sprintf("%s/calls/recent", topicPrefix),
+		Payload:  payload,
+		Retained: true,
+		Class:    ClassState,
+	}
+}
@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"os"
+	"strings"
+)
+
+type Config struct {
+	Boxes []BoxConfig `json:"boxes"`
+}
+
+type BoxConfig struct {
+	Name            string `json:"name"`
+	BaseURL         string `json:"baseUrl"`
+	Username        string `json:"username"`
+	Password        string `json:"password"`
+	Insecure        bool   `json:"insecure,omitempty"`
+	CAFile          string `json:"caFile,omitempty"`
+	Fingerprint     string `json:"fingerprint,omitempty"`
+	FingerprintFile string `json:"fingerprintFile,omitempty"`
+}
+
+func (b BoxConfig) TLSOptions() fritzbox.TLSOptions {
+	return fritzbox.TLSOptions{
+		Insecure:        b.Insecure,
+		CAFile:          b.CAFile,
+		Fingerprint:     b.Fingerprint,
+		FingerprintFile: b.FingerprintFile,
+	}
+}
+
+// Topics of a named box are namespaced below the prefix, a single unnamed box publishes directly below it
+func (b BoxConfig) TopicPrefix(topicPrefix string) string {
+	if b.Name == "" {
+		return topicPrefix
+	}
+	return topicPrefix + "/" + b.Name
+}
+
+func LoadConfig(file string) (Config, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var config Config
+	if errUnmarshal := json.Unmarshal(content, &config); errUnmarshal != nil {
+		return Config{}, fmt.Errorf("invalid config %s: %w", file, errUnmarshal)
+	}
+
+	if errValidate := config.validate(); errValidate != nil {
+		return Config{}, fmt.Errorf("invalid config %s: %w", file, errValidate)
+	}
+
+	return config, nil
+}
+
+func (c Config) validate() error {
+	if len(c.Boxes) == 0 {
+		return fmt.Errorf("no boxes configured")
+	}
+
+	names := map[string]bool{}
+	for i, box := range c.Boxes {
+		if box.Name == "" {
+			return fmt.Errorf("box %d has no name", i+1)
+		}
+		if strings.ContainsAny(box.Name, "/+# ") {
+			return fmt.Errorf("box name %q must not contain '/', '+', '#' or spaces", box.Name)
+		}
+		if names[box.Name] {
+			return fmt.Errorf("box name %q is used more than once", box.Name)
+		}
+		names[box.Name] = true
+		if box.BaseURL == "" || box.Username == "" || box.Password == "" {
+			return fmt.Errorf("box %s requires baseUrl, username and password", box.Name)
+		}
+	}
+
+	return nil
+}
+
+func (c Config) Box(name string) (BoxConfig, error) {
+	if name == "" {
+		return c.Boxes[0], nil
+	}
+	for _, box := range c.Boxes {
+		if box.Name == name {
+			return box, nil
+		}
+	}
+	return BoxConfig{}, fmt.Errorf("unknown box %s", name)
+}
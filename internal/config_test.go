@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_LoadConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	content := `{"boxes": [
+		{"name": "main", "baseUrl": "https://fritz.box", "username": "admin", "password": "secret"},
+		{"name": "barn", "baseUrl": "https://192.168.179.1", "username": "admin", "password": "secret", "insecure": true}
+	]}`
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	barn, err := config.Box("barn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !barn.TLSOptions().Insecure || barn.TopicPrefix("fritze") != "fritze/barn" {
+		t.Errorf("invalid box %+v", barn)
+	}
+
+	duplicate := Config{Boxes: []BoxConfig{config.Boxes[0], config.Boxes[0]}}
+	if err := duplicate.validate(); err == nil {
+		t.Error("duplicate box names accepted")
+	}
+
+	if (BoxConfig{}).TopicPrefix("fritze") != "fritze" {
+		t.Error("unnamed box namespaced")
+	}
+}
@@ -1,44 +1,175 @@
 package internal
 
 import (
+	"errors"
+	"fmt"
 	"github.com/webishdev/fritze-mqtt/fritzbox"
 	"github.com/webishdev/fritze-mqtt/log"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
-func StartController(controllerChan chan byte, fc fritzbox.FritzClient, username string, password string) error {
+type ControllerOptions struct {
+	TopicPrefix     string
+	HomeAssistant   bool
+	DiscoveryPrefix string
+	Layout          string
+	HomieRoot       string
+	HomieVersion    int
+	HomieName       string
+	StatusTopic     string
+	Template        *DeviceTemplate
+	// Topics of devices removed while the bridge was down are cleared on startup
+	RetainedStateFile string
+}
+
+func (o ControllerOptions) deviceTemplate() *DeviceTemplate {
+	if o.Template == nil {
+		return defaultDeviceTemplate
+	}
+	return o.Template
+}
+
+// Commands without a topic ID apply to all devices, the devices are polled right after each command
+type DeviceCommand struct {
+	TopicID string
+	Apply   func(fc fritzbox.FritzClient, session fritzbox.Session, identifier string) error
+	// Publishes the current values again, even if they did not change
+	Refresh bool
+	// Receives the devices of the next poll
+	Devices func(devices []fritzbox.Device)
+}
+
+type devicePoll struct {
+	devices []fritzbox.Device
+	refresh []string
+}
+
+func StartController(controllerChan chan byte, reloginChan chan byte, commandChan chan DeviceCommand, fc fritzbox.FritzClient, username string, password string, publishChan chan Message, options ControllerOptions) error {
+	sessionAvailability := newAvailability(publishChan, options.TopicPrefix)
+	defer sessionAvailability.shutdown()
+
 	session, errLogin := fc.Login(username, password)
-	if errLogin != nil {
-		return errLogin
+	for errLogin != nil {
+		sessionAvailability.set(false)
+		// The box may be unreachable at startup, keep trying instead of giving up on it
+		log.Error("Could not log in: %s, retrying in 30s", errLogin)
+		select {
+		case <-controllerChan:
+			return nil
+		case <-time.After(30 * time.Second):
+		}
+		session, errLogin = fc.Login(username, password)
 	}
 
-	deviceChan := make(chan []fritzbox.Device)
+	deviceChan := make(chan devicePoll)
+
+	var homie *homieDevice
+	if options.Layout == LayoutHomie {
+		homie = newHomieDevice(options.HomieRoot, options.HomieVersion, options.HomieName)
+	}
 
-	go handler(deviceChan)
+	handlerDone := make(chan struct{})
+	go func() {
+		defer close(handlerDone)
+		handler(deviceChan, publishChan, options, homie)
+	}()
 
-	return loop(controllerChan, fc, session, deviceChan)
+	errLoop := loop(controllerChan, reloginChan, commandChan, fc, session, username, password, deviceChan, sessionAvailability)
+	// The last values of the handler are published before the bridge goes away
+	close(deviceChan)
+	<-handlerDone
+	if homie != nil {
+		publishChan <- Message{Topic: HomieStateTopic(options.HomieRoot), Payload: []byte("disconnected"), Retained: true}
+	}
+	return errLoop
 }
 
-func loop(controllerChan chan byte, fc fritzbox.FritzClient, session fritzbox.Session, deviceChan chan []fritzbox.Device) error {
+func loop(controllerChan chan byte, reloginChan chan byte, commandChan chan DeviceCommand, fc fritzbox.FritzClient, session fritzbox.Session, username string, password string, deviceChan chan devicePoll, sessionAvailability *availability) error {
+	topicIDToIdentifier := map[string]string{}
+	var refresh []string
+	var replies []func(devices []fritzbox.Device)
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 	for {
 		devices, errDevices := getDevices(fc, session)
 		if errDevices != nil {
-			return errDevices
+			log.Error("Could not read devices: %s", errDevices)
+			sessionAvailability.set(false)
+			// Requests for the devices are not kept around until the FRITZ!Box is back
+			if len(replies) > 0 {
+				log.Warn("Dropping %d requests for the devices", len(replies))
+				replies = nil
+			}
+			// The session is gone after a reboot of the FRITZ!Box, other errors are retried on the next tick
+			if errors.Is(errDevices, fritzbox.ErrSessionInvalid) {
+				session = relogin(fc, session, username, password)
+			}
+		} else {
+			sessionAvailability.set(true)
+			for _, device := range devices {
+				topicIDToIdentifier[strings.ToLower(deviceTopicID(device.Identifier))] = device.Identifier
+			}
+			for _, reply := range replies {
+				reply(devices)
+			}
+			deviceChan <- devicePoll{devices: devices, refresh: refresh}
+			refresh, replies = nil, nil
 		}
-		deviceChan <- devices
 		select {
 		case <-controllerChan:
 			{
 				errLogout := fc.Logout(session)
 				return errLogout
 			}
+		case <-reloginChan:
+			session = relogin(fc, session, username, password)
+		case command := <-commandChan:
+			// The devices are read again right away to publish the new state
+			identifier := ""
+			if command.TopicID != "" {
+				var exists bool
+				identifier, exists = topicIDToIdentifier[strings.ToLower(command.TopicID)]
+				if !exists {
+					log.Warn("Unknown device %s", command.TopicID)
+					continue
+				}
+			}
+			if command.Apply != nil {
+				if errCommand := command.Apply(fc, session, identifier); errCommand != nil {
+					log.Error("Command for device %s failed: %s", identifier, errCommand)
+					if errors.Is(errCommand, fritzbox.ErrSessionInvalid) {
+						session = relogin(fc, session, username, password)
+					}
+				}
+			}
+			if command.Refresh && !slices.Contains(refresh, identifier) {
+				refresh = append(refresh, identifier)
+			}
+			if command.Devices != nil {
+				replies = append(replies, command.Devices)
+			}
 		case <-ticker.C:
 		}
 	}
 }
 
+func relogin(fc fritzbox.FritzClient, session fritzbox.Session, username string, password string) fritzbox.Session {
+	log.Info("Re-establishing session")
+	// The previous session may still be alive, it would otherwise linger until it expires
+	if errLogout := fc.Logout(session); errLogout != nil {
+		log.Debug("Could not log out previous session: %s", errLogout)
+	}
+	newSession, errLogin := fc.Login(username, password)
+	if errLogin != nil {
+		log.Error("Could not log in again: %s", errLogin)
+		return session
+	}
+	return newSession
+}
+
 func getDevices(fc fritzbox.FritzClient, session fritzbox.Session) ([]fritzbox.Device, error) {
 	devices, errDevices := fc.GetDevices(session)
 	if errDevices != nil {
@@ -48,29 +179,287 @@ func getDevices(fc fritzbox.FritzClient, session fritzbox.Session) ([]fritzbox.D
 	return devices, nil
 }
 
-func handler(deviceChan chan []fritzbox.Device) {
+func handler(deviceChan chan devicePoll, publishChan chan Message, options ControllerOptions, homie *homieDevice) {
 	identifierToDevice := map[string]fritzbox.Device{}
-	for {
-		select {
-		case devices := <-deviceChan:
+	identifierToValues := map[string]map[string]string{}
+	retained := newRetainedTopics(options.RetainedStateFile)
+	first := true
+	for poll := range deviceChan {
+		func() {
+			// A device the handler can not cope with must not stop the polling
+			defer recoverPanic("device handler")
+			devices := poll.devices
 			log.Info("Received %d devices\n", len(devices))
+			for _, identifier := range poll.refresh {
+				if identifier == "" {
+					clear(identifierToValues)
+				}
+				delete(identifierToValues, identifier)
+			}
+			if homie != nil {
+				homie.sync(publishChan, devices)
+			}
+			current := map[string]bool{}
+			for _, device := range devices {
+				current[device.Identifier] = true
+			}
+			if first {
+				retained.clearStale(publishChan, current)
+				first = false
+			}
 			for _, device := range devices {
-				current, exists := identifierToDevice[device.Identifier]
+				previous, exists := identifierToDevice[device.Identifier]
+				identifierToDevice[device.Identifier] = device
+				if homie != nil {
+					identifierToValues[device.Identifier] = homie.publishValues(publishChan, device, identifierToValues[device.Identifier])
+				} else {
+					identifierToValues[device.Identifier] = publishDeviceValues(publishChan, options, device, identifierToValues[device.Identifier], retained)
+				}
 				if exists {
-					if device.Triggered && current.StateValue == device.StateValue {
+					if device.Triggered && previous.StateValue == device.StateValue {
 						log.Info("Device %s: %s, [%s] is currently triggered", device.Identifier, device.Name, device.Description)
 					}
-					if current.StateValue != device.StateValue {
-						log.Info("Device %s: %s, [%s] changed from %d to %d", device.Identifier, device.Name, device.Description, current.StateValue, device.StateValue)
+					if previous.StateValue != device.StateValue {
+						log.Info("Device %s: %s, [%s] changed from %d to %d", device.Identifier, device.Name, device.Description, previous.StateValue, device.StateValue)
+					}
+					for _, event := range pressedButtons(previous, device) {
+						log.Info("Device %s: %s, [%s] was pressed: %s", device.Identifier, device.Name, device.Description, event)
+						if homie != nil {
+							homie.publishEvent(publishChan, device, event)
+						} else {
+							publishDeviceEvent(publishChan, options, device, event, retained)
+						}
 					}
-					identifierToDevice[device.Identifier] = device
 				} else {
-					identifierToDevice[device.Identifier] = device
 					log.Debug("New device %s: %s, [%s]", device.Identifier, device.Name, device.Description)
-					continue
+					if options.HomeAssistant && homie == nil {
+						for _, topic := range publishHomeAssistant(publishChan, options, device) {
+							retained.track(publishChan, device.Identifier, topic, topic)
+						}
+					}
 				}
+			}
 
+			for identifier, device := range identifierToDevice {
+				if current[identifier] {
+					continue
+				}
+				log.Info("Device %s: %s was removed", identifier, device.Name)
+				retained.remove(publishChan, identifier)
+				delete(identifierToDevice, identifier)
+				delete(identifierToValues, identifier)
 			}
+			retained.save()
+		}()
+	}
+}
+
+func DeviceCommands(topicPrefix string, commandChan chan DeviceCommand) []Command {
+	command := func(topic string, capability string, topicIDLevel int) Command {
+		return Command{
+			Topic: fmt.Sprintf("%s/%s", topicPrefix, topic),
+			Handler: func(topic string, payload []byte) {
+				apply, errPayload := newDeviceApply(capability, payload)
+				if errPayload != nil {
+					log.Warn("Invalid payload %q for %s: %s", payload, topic, errPayload)
+					return
+				}
+				commandChan <- DeviceCommand{TopicID: topicLevel(topic, topicIDLevel), Apply: apply}
+			},
+		}
+	}
+
+	return []Command{
+		command("+/set", "state", -2),
+		command("+/target_temperature/set", "target_temperature", -3),
+		command("+/mode/set", "mode", -3),
+		command("+/level/set", "level", -3),
+		command("+/cover/set", "cover", -3),
+		{
+			Topic: fmt.Sprintf("%s/+/get", topicPrefix),
+			Handler: func(topic string, payload []byte) {
+				commandChan <- DeviceCommand{TopicID: topicLevel(topic, -2), Refresh: true}
+			},
+		},
+	}
+}
+
+// Maps a payload written to a capability to the matching AHA command
+func newDeviceApply(capability string, payload []byte) (func(fc fritzbox.FritzClient, session fritzbox.Session, identifier string) error, error) {
+	value := strings.TrimSpace(string(payload))
+	switch capability {
+	case "state":
+		on, errPayload := parseOnOff(payload)
+		if errPayload != nil {
+			return nil, errPayload
+		}
+		return func(fc fritzbox.FritzClient, session fritzbox.Session, identifier string) error {
+			return fc.SetSimpleOnOff(session, identifier, on)
+		}, nil
+	case "target_temperature":
+		// The thermostat is switched off and on with these instead of a temperature
+		if on, errOnOff := parseOnOff(payload); errOnOff == nil {
+			return func(fc fritzbox.FritzClient, session fritzbox.Session, identifier string) error {
+				return fc.SetThermostat(session, identifier, on)
+			}, nil
+		}
+		celsius, errPayload := strconv.ParseFloat(value, 64)
+		if errPayload != nil {
+			return nil, errPayload
+		}
+		return func(fc fritzbox.FritzClient, session fritzbox.Session, identifier string) error {
+			return fc.SetTargetTemperature(session, identifier, celsius)
+		}, nil
+	case "mode":
+		var on bool
+		switch strings.ToLower(value) {
+		case "heat":
+			on = true
+		case "off":
+		default:
+			return nil, fmt.Errorf("expected heat or off")
 		}
+		return func(fc fritzbox.FritzClient, session fritzbox.Session, identifier string) error {
+			return fc.SetThermostat(session, identifier, on)
+		}, nil
+	case "level":
+		level, errPayload := strconv.Atoi(value)
+		if errPayload != nil {
+			return nil, errPayload
+		}
+		return func(fc fritzbox.FritzClient, session fritzbox.Session, identifier string) error {
+			return fc.SetLevelPercentage(session, identifier, level)
+		}, nil
+	case "cover":
+		target := strings.ToLower(value)
+		return func(fc fritzbox.FritzClient, session fritzbox.Session, identifier string) error {
+			return fc.SetBlind(session, identifier, target)
+		}, nil
+	default:
+		return nil, fmt.Errorf("%s is not writable", capability)
+	}
+}
+
+// Publishes the values which changed since the last publish and returns the current ones
+func publishDeviceValues(publishChan chan Message, options ControllerOptions, device fritzbox.Device, previous map[string]string, retained *retainedTopics) map[string]string {
+	values := deviceValues(device)
+	for capability, value := range values {
+		if last, exists := previous[capability]; exists && last == value {
+			continue
+		}
+		topic, payload, errRender := options.deviceTemplate().render(options.TopicPrefix, device, capability, value)
+		if errRender != nil {
+			log.Error("Could not render %s of %s: %s", capability, device.Identifier, errRender)
+			continue
+		}
+		retained.track(publishChan, device.Identifier, capability, topic)
+		publishChan <- Message{
+			Topic:      topic,
+			Payload:    payload,
+			Retained:   true,
+			Class:      ClassState,
+			Properties: deviceProperties(device),
+		}
+	}
+	return values
+}
+
+func deviceValues(device fritzbox.Device) map[string]string {
+	values := map[string]string{}
+	if device.StateValue >= 0 {
+		values["state"] = strconv.Itoa(device.StateValue)
+	}
+	if device.Temperature != nil {
+		values["temperature"] = strconv.FormatFloat(*device.Temperature, 'f', -1, 64)
+	}
+	if device.Humidity != nil {
+		values["humidity"] = strconv.Itoa(*device.Humidity)
+	}
+	if device.Power != nil {
+		values["power"] = strconv.FormatFloat(*device.Power, 'f', -1, 64)
 	}
+	if device.Energy != nil {
+		values["energy"] = strconv.Itoa(*device.Energy)
+	}
+	// Off and fully open are the ends of the range of target temperatures, like on the FRITZ!Box
+	switch {
+	case device.TargetTemperature != nil:
+		values["target_temperature"] = strconv.FormatFloat(*device.TargetTemperature, 'f', -1, 64)
+	case device.ThermostatMode == "off":
+		values["target_temperature"] = "8"
+	case device.ThermostatMode == "on":
+		values["target_temperature"] = "28"
+	}
+	if device.ThermostatMode != "" {
+		values["mode"] = thermostatMode(device)
+	}
+	if device.Level != nil {
+		values["level"] = strconv.Itoa(*device.Level)
+	}
+	if device.BatteryLevel != nil {
+		values["battery"] = strconv.Itoa(*device.BatteryLevel)
+	}
+	return values
+}
+
+// Events of the buttons pressed since the previous poll, e.g. button_2_long
+func pressedButtons(previous fritzbox.Device, device fritzbox.Device) []string {
+	lastPressed := map[string]int64{}
+	for _, button := range previous.Buttons {
+		lastPressed[buttonEvent(button)] = button.LastPressed
+	}
+	var events []string
+	for _, button := range device.Buttons {
+		event := buttonEvent(button)
+		if last, exists := lastPressed[event]; exists && last != button.LastPressed {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func buttonEvent(button fritzbox.Button) string {
+	press := "short"
+	if button.LongPress {
+		press = "long"
+	}
+	return fmt.Sprintf("button_%d_%s", button.Number, press)
+}
+
+// Heating follows the target temperature or keeps the valve fully open, both are heat
+func thermostatMode(device fritzbox.Device) string {
+	if device.ThermostatMode == "off" {
+		return "off"
+	}
+	return "heat"
+}
+
+func publishDeviceEvent(publishChan chan Message, options ControllerOptions, device fritzbox.Device, event string, retained *retainedTopics) {
+	topic, payload, errRender := options.deviceTemplate().render(options.TopicPrefix, device, "event", event)
+	if errRender != nil {
+		log.Error("Could not render event of %s: %s", device.Identifier, errRender)
+		return
+	}
+	retained.track(publishChan, device.Identifier, "event", topic)
+	publishChan <- Message{
+		Topic:      topic,
+		Payload:    payload,
+		Class:      ClassEvent,
+		Expiry:     eventExpiry,
+		Properties: deviceProperties(device),
+	}
+}
+
+// Sent as MQTT 5 user properties, so subscribers need not map topics back to devices
+func deviceProperties(device fritzbox.Device) map[string]string {
+	return map[string]string{
+		"ain":     device.Identifier,
+		"product": device.ProductName,
+		"type":    deviceType(device),
+	}
+}
+
+// AINs contain spaces, e.g. "11630 0123456-1", which are dropped for topics
+func deviceTopicID(identifier string) string {
+	return strings.ReplaceAll(identifier, " ", "")
 }
@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"testing"
+)
+
+type thermostatClient struct {
+	fritzbox.FritzClient
+	on     *bool
+	target float64
+}
+
+func (c *thermostatClient) SetThermostat(_ fritzbox.Session, _ string, on bool) error {
+	c.on = &on
+	return nil
+}
+
+func (c *thermostatClient) SetTargetTemperature(_ fritzbox.Session, _ string, celsius float64) error {
+	c.target = celsius
+	return nil
+}
+
+func Test_newDeviceApply(t *testing.T) {
+	on, off := true, false
+	tests := []struct {
+		capability string
+		payload    string
+		on         *bool
+		target     float64
+		wantErr    bool
+	}{
+		{"target_temperature", "21.5", nil, 21.5, false},
+		{"target_temperature", "off", &off, 0, false},
+		{"target_temperature", "on", &on, 0, false},
+		{"target_temperature", "warm", nil, 0, true},
+		{"mode", "heat", &on, 0, false},
+		{"mode", "OFF", &off, 0, false},
+		{"mode", "cool", nil, 0, true},
+		{"temperature", "21", nil, 0, true},
+	}
+	for _, tt := range tests {
+		apply, err := newDeviceApply(tt.capability, []byte(tt.payload))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newDeviceApply(%s, %s) error = %v, wantErr %v", tt.capability, tt.payload, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		client := &thermostatClient{}
+		if errApply := apply(client, nil, "13979 0878454"); errApply != nil {
+			t.Fatal(errApply)
+		}
+		if (client.on == nil) != (tt.on == nil) || (client.on != nil && *client.on != *tt.on) || client.target != tt.target {
+			t.Errorf("newDeviceApply(%s, %s) switched %v to %v, expected %v to %v", tt.capability, tt.payload, client.on, client.target, tt.on, tt.target)
+		}
+	}
+}
+
+func Test_deviceValuesThermostat(t *testing.T) {
+	target := 21.5
+	tests := []struct {
+		device fritzbox.Device
+		target string
+		mode   string
+	}{
+		{fritzbox.Device{ThermostatMode: "auto", TargetTemperature: &target}, "21.5", "heat"},
+		{fritzbox.Device{ThermostatMode: "off"}, "8", "off"},
+		{fritzbox.Device{ThermostatMode: "on"}, "28", "heat"},
+	}
+	for _, tt := range tests {
+		values := deviceValues(tt.device)
+		if values["target_temperature"] != tt.target || values["mode"] != tt.mode {
+			t.Errorf("deviceValues(%s) = %v", tt.device.ThermostatMode, values)
+		}
+	}
+}
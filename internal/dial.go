@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"github.com/webishdev/fritze-mqtt/log"
+	"strings"
+)
+
+type dialRequest struct {
+	Number string `json:"number"`
+	Phone  string `json:"phone,omitempty"`
+}
+
+func Dial(tc fritzbox.TR064Client, number string, phone string) error {
+	if errDial := fritzbox.DialNumber(tc, number, phone); errDial != nil {
+		return errDial
+	}
+
+	fmt.Printf("Dialing %s\n", number)
+
+	return nil
+}
+
+func Hangup(tc fritzbox.TR064Client) error {
+	if errHangup := fritzbox.DialHangup(tc); errHangup != nil {
+		return errHangup
+	}
+
+	fmt.Println("Hung up")
+
+	return nil
+}
+
+// The dial payload is either the plain number or a JSON object with number and phone
+func DialCommands(tc fritzbox.TR064Client, topicPrefix string, defaultPhone string) []Command {
+	return []Command{
+		{
+			Topic: fmt.Sprintf("%s/dial/number", topicPrefix),
+			Handler: inBackground(func(topic string, payload []byte) {
+				request, errPayload := parseDialRequest(payload, defaultPhone)
+				if errPayload != nil {
+					log.Warn("Invalid payload %q for %s: %s", payload, topic, errPayload)
+					return
+				}
+
+				if errDial := fritzbox.DialNumber(tc, request.Number, request.Phone); errDial != nil {
+					log.Error("Could not dial %s: %s", request.Number, errDial)
+					return
+				}
+				log.Info("Dialing %s", request.Number)
+			}),
+		},
+		{
+			Topic: fmt.Sprintf("%s/dial/hangup", topicPrefix),
+			Handler: inBackground(func(topic string, payload []byte) {
+				if errHangup := fritzbox.DialHangup(tc); errHangup != nil {
+					log.Error("Could not hang up: %s", errHangup)
+					return
+				}
+				log.Info("Hung up")
+			}),
+		},
+	}
+}
+
+func parseDialRequest(payload []byte, defaultPhone string) (dialRequest, error) {
+	request := dialRequest{
+		Phone: defaultPhone,
+	}
+
+	trimmed := strings.TrimSpace(string(payload))
+	if strings.HasPrefix(trimmed, "{") {
+		if errUnmarshal := json.Unmarshal([]byte(trimmed), &request); errUnmarshal != nil {
+			return dialRequest{}, errUnmarshal
+		}
+	} else {
+		request.Number = trimmed
+	}
+
+	if request.Number == "" {
+		return dialRequest{}, fmt.Errorf("no number to dial")
+	}
+
+	return request, nil
+}
@@ -0,0 +1,29 @@
+package internal
+
+import "testing"
+
+func Test_parseDialRequest(t *testing.T) {
+	tests := []struct {
+		payload  string
+		expected dialRequest
+		wantErr  bool
+	}{
+		{"**610", dialRequest{Number: "**610", Phone: "**1"}, false},
+		{" 0301234567\n", dialRequest{Number: "0301234567", Phone: "**1"}, false},
+		{`{"number": "**9", "phone": "**2"}`, dialRequest{Number: "**9", Phone: "**2"}, false},
+		{`{"number": "**9"}`, dialRequest{Number: "**9", Phone: "**1"}, false},
+		{`{"phone": "**2"}`, dialRequest{}, true},
+		{`{"number": `, dialRequest{}, true},
+		{"", dialRequest{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseDialRequest([]byte(tt.payload), "**1")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDialRequest(%q) error = %v, wantErr %v", tt.payload, err, tt.wantErr)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("parseDialRequest(%q) = %+v, expected %+v", tt.payload, got, tt.expected)
+		}
+	}
+}
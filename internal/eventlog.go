@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"github.com/webishdev/fritze-mqtt/log"
+	"os"
+	"slices"
+	"time"
+)
+
+// Numbered entries are tracked by the highest number seen. Entries without a number, from older firmware,
+// are remembered as a whole, neither relies on the clock of the FRITZ!Box.
+type eventLogCursor struct {
+	ID   int      `json:"id,omitempty"`
+	Seen []string `json:"seen,omitempty"`
+}
+
+func StartEventLog(eventLogChan chan byte, tc fritzbox.TR064Client, publishChan chan Message, topicPrefix string, interval time.Duration, stateFile string) error {
+	cursor, errCursor := loadEventLogCursor(stateFile)
+	if errCursor != nil {
+		return errCursor
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		entries, errEntries := fritzbox.GetDeviceLog(tc)
+		if errEntries != nil {
+			log.Error("Could not read device log: %s", errEntries)
+		} else {
+			var fresh []fritzbox.LogEntry
+			initial := cursor == nil
+			fresh, cursor = newLogEntries(cursor, entries)
+			if initial {
+				log.Info("Skipping %d existing device log entries", len(fresh))
+				fresh = nil
+			}
+
+			for _, entry := range fresh {
+				publishLogEntry(publishChan, topicPrefix, entry)
+			}
+
+			if errSave := saveEventLogCursor(stateFile, cursor); errSave != nil {
+				log.Error("Could not save device log state: %s", errSave)
+			}
+		}
+
+		select {
+		case <-eventLogChan:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func newLogEntries(cursor *eventLogCursor, entries []fritzbox.LogEntry) ([]fritzbox.LogEntry, *eventLogCursor) {
+	next := &eventLogCursor{}
+	last := 0
+	if cursor != nil {
+		next.ID = cursor.ID
+		last = cursor.ID
+	}
+	highest := 0
+	for _, entry := range entries {
+		highest = max(highest, entry.ID)
+	}
+	// The numbers start over when the log was cleared
+	if highest > 0 && highest < last {
+		next.ID, last = 0, 0
+	}
+
+	var fresh []fritzbox.LogEntry
+	for _, entry := range entries {
+		if entry.ID > 0 {
+			if entry.ID > last {
+				fresh = append(fresh, entry)
+				next.ID = max(next.ID, entry.ID)
+			}
+			continue
+		}
+
+		id := logEntryID(entry)
+		next.Seen = append(next.Seen, id)
+		if cursor == nil || !slices.Contains(cursor.Seen, id) {
+			fresh = append(fresh, entry)
+		}
+	}
+
+	return fresh, next
+}
+
+func logEntryID(entry fritzbox.LogEntry) string {
+	sum := sha256.Sum256([]byte(entry.Time.Format(time.RFC3339) + entry.Message))
+	return hex.EncodeToString(sum[:8])
+}
+
+func loadEventLogCursor(stateFile string) (*eventLogCursor, error) {
+	if stateFile == "" {
+		return nil, nil
+	}
+
+	content, err := os.ReadFile(stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor eventLogCursor
+	if errUnmarshal := json.Unmarshal(content, &cursor); errUnmarshal != nil {
+		return nil, fmt.Errorf("invalid device log state in %s: %w", stateFile, errUnmarshal)
+	}
+
+	return &cursor, nil
+}
+
+func saveEventLogCursor(stateFile string, cursor *eventLogCursor) error {
+	if stateFile == "" {
+		return nil
+	}
+
+	content, err := json.Marshal(cursor)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(stateFile, content, 0600)
+}
+
+func publishLogEntry(publishChan chan Message, topicPrefix string, entry fritzbox.LogEntry) {
+	payload, errMarshal := json.Marshal(entry)
+	if errMarshal != nil {
+		log.Error("Could not marshal device log entry: %s", errMarshal)
+		return
+	}
+
+	publishChan <- Message{
+		Topic:   fmt.Sprintf("%s/eventlog", topicPrefix),
+		Payload: payload,
+		Class:   ClassEvent,
+	}
+}
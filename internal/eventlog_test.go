@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_newLogEntries(t *testing.T) {
+	start := time.Date(2025, 7, 9, 18, 0, 0, 0, time.Local)
+	first := fritzbox.LogEntry{Time: start, Message: "first"}
+	second := fritzbox.LogEntry{Time: start.Add(time.Second), Message: "second"}
+	third := fritzbox.LogEntry{Time: start.Add(time.Second), Message: "third"}
+
+	fresh, cursor := newLogEntries(nil, []fritzbox.LogEntry{first, second})
+	if len(fresh) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(fresh))
+	}
+
+	fresh, cursor = newLogEntries(cursor, []fritzbox.LogEntry{first, second, third})
+	if len(fresh) != 1 || fresh[0].Message != "third" {
+		t.Errorf("expected only the third entry, got %+v", fresh)
+	}
+
+	stateFile := filepath.Join(t.TempDir(), "eventlog.json")
+	if err := saveEventLogCursor(stateFile, cursor); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadEventLogCursor(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fresh, _ = newLogEntries(loaded, []fritzbox.LogEntry{first, second, third})
+	if len(fresh) != 0 {
+		t.Errorf("entries repeated after restart %+v", fresh)
+	}
+}
+
+func Test_newLogEntriesNumbered(t *testing.T) {
+	start := time.Date(2025, 7, 9, 18, 0, 0, 0, time.UTC)
+	first := fritzbox.LogEntry{ID: 41, Time: start, Message: "first"}
+	second := fritzbox.LogEntry{ID: 42, Time: start.Add(-time.Hour), Message: "second, after the clock went back"}
+
+	_, cursor := newLogEntries(nil, []fritzbox.LogEntry{first})
+	fresh, cursor := newLogEntries(cursor, []fritzbox.LogEntry{first, second})
+	if len(fresh) != 1 || fresh[0].ID != 42 || cursor.ID != 42 {
+		t.Errorf("expected only the second entry, got %+v", fresh)
+	}
+
+	cleared := fritzbox.LogEntry{ID: 1, Time: start, Message: "log cleared"}
+	fresh, cursor = newLogEntries(cursor, []fritzbox.LogEntry{cleared})
+	if len(fresh) != 1 || cursor.ID != 1 {
+		t.Errorf("expected the entry of the cleared log, got %+v", fresh)
+	}
+}
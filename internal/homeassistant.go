@@ -0,0 +1,247 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"github.com/webishdev/fritze-mqtt/log"
+	"slices"
+)
+
+type homeAssistantDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	SWVersion    string   `json:"sw_version,omitempty"`
+}
+
+type homeAssistantSensor struct {
+	capability  string
+	name        string
+	deviceClass string
+	unit        string
+	stateClass  string
+	diagnostic  bool
+}
+
+var homeAssistantSensors = []homeAssistantSensor{
+	{capability: "temperature", name: "Temperature", deviceClass: "temperature", unit: "°C", stateClass: "measurement"},
+	{capability: "humidity", name: "Humidity", deviceClass: "humidity", unit: "%", stateClass: "measurement"},
+	{capability: "power", name: "Power", deviceClass: "power", unit: "W", stateClass: "measurement"},
+	{capability: "energy", name: "Energy", deviceClass: "energy", unit: "Wh", stateClass: "total_increasing"},
+	{capability: "battery", name: "Battery", deviceClass: "battery", unit: "%", stateClass: "measurement", diagnostic: true},
+}
+
+// Publishes the discovery configs of a device and returns their topics to remove them later
+func publishHomeAssistant(publishChan chan Message, options ControllerOptions, device fritzbox.Device) []string {
+	configs := homeAssistantConfigs(options, device)
+
+	var topics []string
+	for topic, config := range configs {
+		payload, errMarshal := json.Marshal(config)
+		if errMarshal != nil {
+			log.Error("Could not marshal Home Assistant config for %s: %s", device.Identifier, errMarshal)
+			continue
+		}
+		publishChan <- Message{
+			Topic:    topic,
+			Payload:  payload,
+			Retained: true,
+			Class:    ClassDiscovery,
+		}
+		topics = append(topics, topic)
+	}
+
+	return topics
+}
+
+func homeAssistantConfigs(options ControllerOptions, device fritzbox.Device) map[string]map[string]any {
+	topicPrefix := options.TopicPrefix
+	discoveryPrefix := options.DiscoveryPrefix
+	id := deviceTopicID(device.Identifier)
+	// Commands always use the default layout, states follow the topic template
+	deviceTopic := fmt.Sprintf("%s/%s", topicPrefix, id)
+	// A config without a state topic is of no use, the device is skipped when one can not be rendered
+	var errTopic error
+	stateTopic := func(capability string) string {
+		topic, err := options.deviceTemplate().stateTopic(topicPrefix, device, capability)
+		if err != nil && errTopic == nil {
+			errTopic = fmt.Errorf("could not render topic of %s: %w", capability, err)
+		}
+		return topic
+	}
+	values := deviceValues(device)
+
+	haDevice := homeAssistantDevice{
+		Identifiers:  []string{"fritze_" + deviceTopicID(device.DeviceIdentifier)},
+		Name:         device.Name,
+		Manufacturer: device.Manufacturer,
+		Model:        device.ProductName,
+		SWVersion:    device.FwVersion,
+	}
+
+	// Entities are unavailable when either the bridge or its session with the FRITZ!Box is down
+	availability := []map[string]string{{"topic": sessionTopic(topicPrefix)}}
+	if options.StatusTopic != "" {
+		availability = append(availability, map[string]string{"topic": options.StatusTopic})
+	}
+
+	entity := func(capability string) map[string]any {
+		return map[string]any{
+			"unique_id":         fmt.Sprintf("fritze_%s_%s", id, capability),
+			"device":            haDevice,
+			"availability":      availability,
+			"availability_mode": "all",
+		}
+	}
+
+	configs := map[string]map[string]any{}
+	primaryTopic := func(component string) string {
+		return fmt.Sprintf("%s/%s/%s/config", discoveryPrefix, component, id)
+	}
+
+	switch homeAssistantComponent(device) {
+	case "cover":
+		config := entity("cover")
+		config["name"] = nil
+		config["command_topic"] = deviceTopic + "/cover/set"
+		config["payload_open"] = "OPEN"
+		config["payload_close"] = "CLOSE"
+		config["payload_stop"] = "STOP"
+		if _, exists := values["level"]; exists {
+			config["position_topic"] = stateTopic("level")
+			config["set_position_topic"] = deviceTopic + "/level/set"
+		}
+		configs[primaryTopic("cover")] = config
+	case "light":
+		config := entity("light")
+		config["name"] = nil
+		config["state_topic"] = stateTopic("state")
+		config["command_topic"] = deviceTopic + "/set"
+		config["payload_on"] = "1"
+		config["payload_off"] = "0"
+		if _, exists := values["level"]; exists {
+			config["brightness_state_topic"] = stateTopic("level")
+			config["brightness_command_topic"] = deviceTopic + "/level/set"
+			config["brightness_scale"] = 100
+		}
+		configs[primaryTopic("light")] = config
+	case "climate":
+		config := entity("climate")
+		config["name"] = nil
+		config["current_temperature_topic"] = stateTopic("temperature")
+		config["temperature_state_topic"] = stateTopic("target_temperature")
+		config["temperature_command_topic"] = deviceTopic + "/target_temperature/set"
+		config["min_temp"] = 8
+		config["max_temp"] = 28
+		config["temp_step"] = 0.5
+		config["temperature_unit"] = "C"
+		config["modes"] = []string{"off", "heat"}
+		config["mode_state_topic"] = stateTopic("mode")
+		config["mode_command_topic"] = deviceTopic + "/mode/set"
+		configs[primaryTopic("climate")] = config
+	case "binary_sensor":
+		config := entity("binary_sensor")
+		config["name"] = nil
+		config["state_topic"] = stateTopic("state")
+		config["payload_on"] = "1"
+		config["payload_off"] = "0"
+		if deviceClass := homeAssistantBinarySensorClass(device.UnitType); deviceClass != "" {
+			config["device_class"] = deviceClass
+		}
+		configs[primaryTopic("binary_sensor")] = config
+	case "device_automation":
+		// One trigger per button and press
+		for _, button := range device.Buttons {
+			event := buttonEvent(button)
+			press := "button_short_press"
+			if button.LongPress {
+				press = "button_long_press"
+			}
+			configs[fmt.Sprintf("%s/device_automation/%s_%s/config", discoveryPrefix, id, event)] = map[string]any{
+				"automation_type": "trigger",
+				"topic":           stateTopic("event"),
+				"type":            press,
+				"subtype":         fmt.Sprintf("button_%d", button.Number),
+				"payload":         event,
+				"device":          haDevice,
+			}
+		}
+	case "switch":
+		config := entity("switch")
+		config["name"] = nil
+		config["state_topic"] = stateTopic("state")
+		config["command_topic"] = deviceTopic + "/set"
+		config["payload_on"] = "1"
+		config["payload_off"] = "0"
+		config["state_on"] = "1"
+		config["state_off"] = "0"
+		configs[primaryTopic("switch")] = config
+	}
+
+	for _, sensor := range homeAssistantSensors {
+		if _, exists := values[sensor.capability]; !exists {
+			continue
+		}
+		config := entity(sensor.capability)
+		config["name"] = sensor.name
+		config["state_topic"] = stateTopic(sensor.capability)
+		config["device_class"] = sensor.deviceClass
+		config["unit_of_measurement"] = sensor.unit
+		config["state_class"] = sensor.stateClass
+		if sensor.diagnostic {
+			config["entity_category"] = "diagnostic"
+		}
+		configs[fmt.Sprintf("%s/sensor/%s_%s/config", discoveryPrefix, id, sensor.capability)] = config
+	}
+
+	if errTopic != nil {
+		log.Error("Skipping Home Assistant discovery of %s: %s", device.Identifier, errTopic)
+		return nil
+	}
+	return configs
+}
+
+func homeAssistantComponent(device fritzbox.Device) string {
+	has := func(f fritzbox.DeviceFunction) bool {
+		return slices.Contains(device.Functions, f)
+	}
+
+	switch {
+	case device.UnitType == fritzbox.TypeBlind || device.UnitType == fritzbox.TypeLamellar || has(fritzbox.Blinds):
+		return "cover"
+	case device.UnitType == fritzbox.TypeSimpleLight || device.UnitType == fritzbox.TypeDimmableLight ||
+		device.UnitType == fritzbox.TypeColorBulb || device.UnitType == fritzbox.TypeDimmableColorBulb || has(fritzbox.Light):
+		return "light"
+	case has(fritzbox.AVMHeatingController):
+		return "climate"
+	case device.UnitType >= fritzbox.TypeSimpleDetector && device.UnitType <= fritzbox.TypeVibrationDetector || has(fritzbox.AlarmSensor):
+		return "binary_sensor"
+	case device.UnitType == fritzbox.TypeSimpleButton || has(fritzbox.AVMButton):
+		return "device_automation"
+	case device.StateValue >= 0:
+		return "switch"
+	default:
+		return ""
+	}
+}
+
+func homeAssistantBinarySensorClass(unitType fritzbox.DeviceType) string {
+	switch unitType {
+	case fritzbox.TypeDoorOpenCloseDetector:
+		return "door"
+	case fritzbox.TypeWindowOpenCloseDetector:
+		return "window"
+	case fritzbox.TypeMotionDetector:
+		return "motion"
+	case fritzbox.TypeFloodDetector:
+		return "moisture"
+	case fritzbox.TypeGlassBreakDetector:
+		return "safety"
+	case fritzbox.TypeVibrationDetector:
+		return "vibration"
+	default:
+		return ""
+	}
+}
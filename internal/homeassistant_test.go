@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"testing"
+	"text/template"
+)
+
+func Test_homeAssistantConfigs(t *testing.T) {
+	temperature := 21.5
+	power := 1.52
+	outlet := fritzbox.Device{
+		ProductName:      "FRITZ!DECT 200",
+		Identifier:       "11657 0240192",
+		DeviceIdentifier: "11657 0240192",
+		Manufacturer:     "AVM",
+		FwVersion:        "04.26",
+		Name:             "Washing machine",
+		StateValue:       1,
+		Functions:        []fritzbox.DeviceFunction{fritzbox.AVMPowerMeter, fritzbox.TemperatureSensor, fritzbox.AVMOutletSwitch},
+		Temperature:      &temperature,
+		Power:            &power,
+	}
+
+	configs := homeAssistantConfigs(ControllerOptions{TopicPrefix: "fritze", DiscoveryPrefix: "homeassistant", StatusTopic: "fritze/bridge/status"}, outlet)
+
+	config, exists := configs["homeassistant/switch/116570240192/config"]
+	if !exists {
+		t.Fatalf("no switch config in %v", configs)
+	}
+	if config["command_topic"] != "fritze/116570240192/set" || config["state_topic"] != "fritze/116570240192/state" {
+		t.Errorf("invalid switch config %v", config)
+	}
+
+	device := config["device"].(homeAssistantDevice)
+	if device.Model != "FRITZ!DECT 200" || device.SWVersion != "04.26" {
+		t.Errorf("invalid device %+v", device)
+	}
+
+	if availability := config["availability"].([]map[string]string); len(availability) != 2 || availability[0]["topic"] != "fritze/bridge/session" {
+		t.Errorf("invalid availability %v", availability)
+	}
+
+	if _, exists := configs["homeassistant/sensor/116570240192_power/config"]; !exists {
+		t.Error("no power sensor config")
+	}
+
+	if len(configs) != 3 {
+		t.Errorf("expected 3 configs, got %d", len(configs))
+	}
+
+	contact := fritzbox.Device{Identifier: "13077 0012345-1", UnitType: fritzbox.TypeWindowOpenCloseDetector, StateValue: 0}
+	if homeAssistantComponent(contact) != "binary_sensor" || homeAssistantBinarySensorClass(contact.UnitType) != "window" {
+		t.Error("contact is not a window sensor")
+	}
+
+	remote := fritzbox.Device{
+		Identifier:       "09995 0000001",
+		DeviceIdentifier: "09995 0000001",
+		Functions:        []fritzbox.DeviceFunction{fritzbox.AVMButton},
+		StateValue:       -1,
+		Buttons: []fritzbox.Button{
+			{Identifier: "09995 0000001-1", Number: 1},
+			{Identifier: "09995 0000001-9", Number: 1, LongPress: true},
+		},
+	}
+	triggers := homeAssistantConfigs(ControllerOptions{TopicPrefix: "fritze", DiscoveryPrefix: "homeassistant"}, remote)
+	long, exists := triggers["homeassistant/device_automation/099950000001_button_1_long/config"]
+	if len(triggers) != 2 || !exists {
+		t.Fatalf("expected a trigger per press, got %v", triggers)
+	}
+	if long["type"] != "button_long_press" || long["subtype"] != "button_1" || long["payload"] != "button_1_long" || long["topic"] != "fritze/099950000001/event" {
+		t.Errorf("invalid trigger %v", long)
+	}
+
+	// Renders no topic for the state of the switch
+	broken := &DeviceTemplate{
+		topic:       template.Must(template.New("topic").Parse(`{{if ne .Capability "state"}}fritze/{{.ID}}/{{.Capability}}{{end}}`)),
+		payloadMode: PayloadRaw,
+	}
+	if configs := homeAssistantConfigs(ControllerOptions{TopicPrefix: "fritze", DiscoveryPrefix: "homeassistant", Template: broken}, outlet); len(configs) != 0 {
+		t.Errorf("expected the device to be skipped, got %v", configs)
+	}
+}
@@ -0,0 +1,295 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"github.com/webishdev/fritze-mqtt/log"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	LayoutDefault = "default"
+	LayoutHomie   = "homie"
+)
+
+type homieProperty struct {
+	Name     string `json:"name"`
+	Datatype string `json:"datatype"`
+	Unit     string `json:"unit,omitempty"`
+	Format   string `json:"format,omitempty"`
+	Settable bool   `json:"settable,omitempty"`
+	Retained *bool  `json:"retained,omitempty"`
+}
+
+type homieNode struct {
+	Name       string                   `json:"name"`
+	Type       string                   `json:"type,omitempty"`
+	Properties map[string]homieProperty `json:"properties"`
+}
+
+type homieDescription struct {
+	Homie   string               `json:"homie"`
+	Version int64                `json:"version"`
+	Name    string               `json:"name"`
+	Nodes   map[string]homieNode `json:"nodes"`
+}
+
+// A FRITZ!Box is a Homie device, every AHA device one of its nodes
+type homieDevice struct {
+	root        string
+	version     int
+	name        string
+	description int64
+	nodes       map[string]homieNode
+	topics      map[string][]string
+}
+
+var homieInvalidID = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// Homie IDs only allow lowercase letters, digits and hyphens
+func HomieID(name string) string {
+	return strings.Trim(homieInvalidID.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+func HomieRoot(prefix string, version int, deviceID string) string {
+	if version >= 5 {
+		return fmt.Sprintf("%s/5/%s", prefix, deviceID)
+	}
+	return fmt.Sprintf("%s/%s", prefix, deviceID)
+}
+
+func HomieStateTopic(root string) string {
+	return root + "/$state"
+}
+
+func newHomieDevice(root string, version int, name string) *homieDevice {
+	return &homieDevice{
+		root:        root,
+		version:     version,
+		name:        name,
+		description: time.Now().UnixMilli(),
+		nodes:       map[string]homieNode{},
+		topics:      map[string][]string{},
+	}
+}
+
+// Publishes the device structure again when devices were added, removed or renamed
+func (h *homieDevice) sync(publishChan chan Message, devices []fritzbox.Device) {
+	nodes := map[string]homieNode{}
+	for _, device := range devices {
+		nodes[homieNodeID(device)] = newHomieNode(device)
+	}
+
+	if reflect.DeepEqual(h.nodes, nodes) {
+		return
+	}
+
+	h.publish(publishChan, "$state", "init")
+
+	for nodeID, topics := range h.topics {
+		if _, exists := nodes[nodeID]; exists {
+			continue
+		}
+		for _, topic := range topics {
+			publishChan <- Message{Topic: topic, Retained: true}
+		}
+		delete(h.topics, nodeID)
+	}
+
+	nodeIDs := make([]string, 0, len(nodes))
+	for nodeID := range nodes {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	slices.Sort(nodeIDs)
+
+	if h.version >= 5 {
+		h.description++
+		payload, errMarshal := json.Marshal(homieDescription{
+			Homie:   "5.0",
+			Version: h.description,
+			Name:    h.name,
+			Nodes:   nodes,
+		})
+		if errMarshal != nil {
+			log.Error("Could not marshal Homie description: %s", errMarshal)
+			return
+		}
+		publishChan <- Message{Topic: h.root + "/$description", Payload: payload, Retained: true}
+		for _, nodeID := range nodeIDs {
+			h.topics[nodeID] = h.valueTopics(nodeID, nodes[nodeID])
+		}
+	} else {
+		h.publish(publishChan, "$homie", "4.0")
+		h.publish(publishChan, "$name", h.name)
+		h.publish(publishChan, "$extensions", "")
+		h.publish(publishChan, "$nodes", strings.Join(nodeIDs, ","))
+		for _, nodeID := range nodeIDs {
+			h.topics[nodeID] = append(h.publishNode(publishChan, nodeID, nodes[nodeID]), h.valueTopics(nodeID, nodes[nodeID])...)
+		}
+	}
+
+	h.nodes = nodes
+	h.publish(publishChan, "$state", "ready")
+}
+
+func (h *homieDevice) publishNode(publishChan chan Message, nodeID string, node homieNode) []string {
+	var topics []string
+	publish := func(attribute string, value string) {
+		topic := fmt.Sprintf("%s/%s", nodeID, attribute)
+		h.publish(publishChan, topic, value)
+		topics = append(topics, fmt.Sprintf("%s/%s", h.root, topic))
+	}
+
+	propertyIDs := sortedPropertyIDs(node)
+	publish("$name", node.Name)
+	publish("$type", node.Type)
+	publish("$properties", strings.Join(propertyIDs, ","))
+	for _, propertyID := range propertyIDs {
+		property := node.Properties[propertyID]
+		publish(propertyID+"/$name", property.Name)
+		publish(propertyID+"/$datatype", property.Datatype)
+		if property.Unit != "" {
+			publish(propertyID+"/$unit", property.Unit)
+		}
+		if property.Format != "" {
+			publish(propertyID+"/$format", property.Format)
+		}
+		if property.Settable {
+			publish(propertyID+"/$settable", "true")
+		}
+		if property.Retained != nil && !*property.Retained {
+			publish(propertyID+"/$retained", "false")
+		}
+	}
+	return topics
+}
+
+func (h *homieDevice) valueTopics(nodeID string, node homieNode) []string {
+	var topics []string
+	for _, propertyID := range sortedPropertyIDs(node) {
+		topics = append(topics, fmt.Sprintf("%s/%s/%s", h.root, nodeID, propertyID))
+	}
+	return topics
+}
+
+// Publishes the values which changed since the last publish and returns the current ones
+func (h *homieDevice) publishValues(publishChan chan Message, device fritzbox.Device, previous map[string]string) map[string]string {
+	values := deviceValues(device)
+	for capability, value := range values {
+		if last, exists := previous[capability]; exists && last == value {
+			continue
+		}
+		if capability == "state" {
+			value = fmt.Sprintf("%t", value == "1")
+		}
+		publishChan <- Message{
+			Topic:      fmt.Sprintf("%s/%s/%s", h.root, homieNodeID(device), capability),
+			Payload:    []byte(value),
+			Retained:   true,
+			Properties: deviceProperties(device),
+		}
+	}
+	return values
+}
+
+func (h *homieDevice) publishEvent(publishChan chan Message, device fritzbox.Device, event string) {
+	publishChan <- Message{
+		Topic:      fmt.Sprintf("%s/%s/event", h.root, homieNodeID(device)),
+		Payload:    []byte(event),
+		Expiry:     eventExpiry,
+		Properties: deviceProperties(device),
+	}
+}
+
+func (h *homieDevice) publish(publishChan chan Message, topic string, value string) {
+	publishChan <- Message{
+		Topic:    fmt.Sprintf("%s/%s", h.root, topic),
+		Payload:  []byte(value),
+		Retained: true,
+	}
+}
+
+func newHomieNode(device fritzbox.Device) homieNode {
+	component := homeAssistantComponent(device)
+	notRetained := false
+	properties := map[string]homieProperty{}
+	for capability := range deviceValues(device) {
+		switch capability {
+		case "state":
+			properties[capability] = homieProperty{Name: "State", Datatype: "boolean", Settable: component == "switch" || component == "light"}
+		case "temperature":
+			properties[capability] = homieProperty{Name: "Temperature", Datatype: "float", Unit: "°C"}
+		case "humidity":
+			properties[capability] = homieProperty{Name: "Humidity", Datatype: "integer", Unit: "%"}
+		case "power":
+			properties[capability] = homieProperty{Name: "Power", Datatype: "float", Unit: "W"}
+		case "energy":
+			properties[capability] = homieProperty{Name: "Energy", Datatype: "integer", Unit: "Wh"}
+		case "target_temperature":
+			properties[capability] = homieProperty{Name: "Target temperature", Datatype: "float", Unit: "°C", Format: "8:28:0.5", Settable: true}
+		case "mode":
+			properties[capability] = homieProperty{Name: "Mode", Datatype: "enum", Format: "off,heat", Settable: true}
+		case "level":
+			properties[capability] = homieProperty{Name: "Level", Datatype: "integer", Unit: "%", Format: "0:100", Settable: true}
+		case "battery":
+			properties[capability] = homieProperty{Name: "Battery", Datatype: "integer", Unit: "%"}
+		}
+	}
+	if component == "cover" {
+		properties["cover"] = homieProperty{Name: "Cover", Datatype: "enum", Format: "open,close,stop", Settable: true, Retained: &notRetained}
+	}
+	if component == "device_automation" {
+		var events []string
+		for _, button := range device.Buttons {
+			events = append(events, buttonEvent(button))
+		}
+		properties["event"] = homieProperty{Name: "Event", Datatype: "enum", Format: strings.Join(events, ","), Retained: &notRetained}
+	}
+
+	return homieNode{
+		Name:       device.Name,
+		Type:       device.ProductName,
+		Properties: properties,
+	}
+}
+
+func homieNodeID(device fritzbox.Device) string {
+	return HomieID(deviceTopicID(device.Identifier))
+}
+
+func sortedPropertyIDs(node homieNode) []string {
+	propertyIDs := make([]string, 0, len(node.Properties))
+	for propertyID := range node.Properties {
+		propertyIDs = append(propertyIDs, propertyID)
+	}
+	slices.Sort(propertyIDs)
+	return propertyIDs
+}
+
+// Writable properties are set on <root>/<node>/<property>/set
+func HomieCommands(root string, commandChan chan DeviceCommand) []Command {
+	return []Command{
+		{
+			Topic: fmt.Sprintf("%s/+/+/set", root),
+			Handler: func(topic string, payload []byte) {
+				apply, errPayload := newDeviceApply(topicLevel(topic, -2), payload)
+				if errPayload != nil {
+					log.Warn("Invalid payload %q for %s: %s", payload, topic, errPayload)
+					return
+				}
+				commandChan <- DeviceCommand{TopicID: topicLevel(topic, -3), Apply: apply}
+			},
+		},
+		{
+			Topic: fmt.Sprintf("%s/+/get", root),
+			Handler: func(topic string, payload []byte) {
+				commandChan <- DeviceCommand{TopicID: topicLevel(topic, -2), Refresh: true}
+			},
+		},
+	}
+}
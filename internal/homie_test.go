@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"encoding/json"
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"testing"
+)
+
+func drainMessages(publishChan chan Message) map[string]string {
+	messages := map[string]string{}
+	for {
+		select {
+		case message := <-publishChan:
+			messages[message.Topic] = string(message.Payload)
+		default:
+			return messages
+		}
+	}
+}
+
+func Test_homieDevice(t *testing.T) {
+	temperature := 21.5
+	devices := []fritzbox.Device{
+		{
+			ProductName: "FRITZ!DECT 200",
+			Identifier:  "11657 0240192",
+			Name:        "Washing machine",
+			StateValue:  1,
+			Functions:   []fritzbox.DeviceFunction{fritzbox.AVMOutletSwitch},
+			Temperature: &temperature,
+		},
+	}
+
+	publishChan := make(chan Message, 100)
+	homie := newHomieDevice(HomieRoot("homie", 4, HomieID("Main Box")), 4, "Main Box")
+	homie.sync(publishChan, devices)
+	homie.publishValues(publishChan, devices[0], nil)
+
+	messages := drainMessages(publishChan)
+	expected := map[string]string{
+		"homie/main-box/$homie":                         "4.0",
+		"homie/main-box/$state":                         "ready",
+		"homie/main-box/$nodes":                         "116570240192",
+		"homie/main-box/116570240192/$properties":       "state,temperature",
+		"homie/main-box/116570240192/state/$datatype":   "boolean",
+		"homie/main-box/116570240192/state/$settable":   "true",
+		"homie/main-box/116570240192/temperature/$unit": "°C",
+		"homie/main-box/116570240192/state":             "true",
+		"homie/main-box/116570240192/temperature":       "21.5",
+	}
+	for topic, payload := range expected {
+		if messages[topic] != payload {
+			t.Errorf("expected %q on %s, got %q", payload, topic, messages[topic])
+		}
+	}
+
+	homie.sync(publishChan, devices)
+	if messages := drainMessages(publishChan); len(messages) != 0 {
+		t.Errorf("unchanged devices published %v", messages)
+	}
+
+	homie.sync(publishChan, nil)
+	messages = drainMessages(publishChan)
+	for _, topic := range []string{"homie/main-box/116570240192/$name", "homie/main-box/116570240192/state", "homie/main-box/$nodes"} {
+		if payload, published := messages[topic]; !published || payload != "" {
+			t.Errorf("expected %s to be cleared, got %v", topic, messages)
+		}
+	}
+}
+
+func Test_homieDevice_version5(t *testing.T) {
+	devices := []fritzbox.Device{{Identifier: "12345 0000001-1", Name: "Kitchen", StateValue: 0, UnitType: fritzbox.TypeBlind}}
+
+	publishChan := make(chan Message, 100)
+	homie := newHomieDevice(HomieRoot("homie", 5, "fritzbox"), 5, "FRITZ!Box")
+	homie.sync(publishChan, devices)
+
+	messages := drainMessages(publishChan)
+	var description homieDescription
+	if err := json.Unmarshal([]byte(messages["homie/5/fritzbox/$description"]), &description); err != nil {
+		t.Fatal(err)
+	}
+	cover := description.Nodes["123450000001-1"].Properties["cover"]
+	if description.Homie != "5.0" || !cover.Settable || cover.Datatype != "enum" {
+		t.Errorf("invalid description %+v", description)
+	}
+}
+
+func Test_HomieCommands(t *testing.T) {
+	commandChan := make(chan DeviceCommand, 2)
+	commands := HomieCommands("homie/fritzbox", commandChan)
+
+	for _, command := range commands {
+		switch command.Topic {
+		case "homie/fritzbox/+/+/set":
+			command.Handler("homie/fritzbox/116570240192/state/set", []byte("true"))
+		case "homie/fritzbox/+/get":
+			command.Handler("homie/fritzbox/116570240192/get", nil)
+		default:
+			t.Errorf("unexpected command topic %s", command.Topic)
+		}
+	}
+
+	set, get := <-commandChan, <-commandChan
+	if set.TopicID != "116570240192" || set.Apply == nil {
+		t.Errorf("invalid set command %+v", set)
+	}
+	if get.TopicID != "116570240192" || !get.Refresh {
+		t.Errorf("invalid get command %+v", get)
+	}
+}
@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"github.com/webishdev/fritze-mqtt/log"
+	"strings"
+	"time"
+)
+
+type hostFilterState struct {
+	Name    string `json:"name"`
+	MAC     string `json:"mac"`
+	IP      string `json:"ip"`
+	Blocked bool   `json:"blocked"`
+}
+
+func BlockHost(tc fritzbox.TR064Client, target string, blocked bool) error {
+	state, errState := setBlocked(tc, target, blocked)
+	if errState != nil {
+		return errState
+	}
+
+	fmt.Printf("%s (%s, %s): blocked=%t\n", state.Name, state.MAC, state.IP, state.Blocked)
+
+	return nil
+}
+
+func StartHostFilter(hostFilterChan chan byte, tc fritzbox.TR064Client, publishChan chan Message, topicPrefix string, interval time.Duration, targets []string) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, target := range targets {
+			state, errState := getBlocked(tc, target)
+			if errState != nil {
+				log.Error("Could not read internet access of %s: %s", target, errState)
+				continue
+			}
+			publishHostFilterState(publishChan, topicPrefix, state)
+		}
+
+		select {
+		case <-hostFilterChan:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func HostFilterCommands(tc fritzbox.TR064Client, publishChan chan Message, topicPrefix string) []Command {
+	return []Command{
+		{
+			Topic: fmt.Sprintf("%s/hostfilter/+/set", topicPrefix),
+			Handler: inBackground(func(topic string, payload []byte) {
+				target := topicLevel(topic, -2)
+				blocked, errPayload := parseBlocked(payload)
+				if errPayload != nil {
+					log.Warn("Invalid payload %q for %s: %s", payload, topic, errPayload)
+					return
+				}
+
+				state, errState := setBlocked(tc, target, blocked)
+				if errState != nil {
+					log.Error("Could not change internet access of %s: %s", target, errState)
+					return
+				}
+				publishHostFilterState(publishChan, topicPrefix, state)
+			}),
+		},
+	}
+}
+
+func getBlocked(tc fritzbox.TR064Client, target string) (hostFilterState, error) {
+	host, errResolve := fritzbox.ResolveHost(tc, target)
+	if errResolve != nil {
+		return hostFilterState{}, errResolve
+	}
+
+	blocked, errBlocked := fritzbox.IsInternetBlocked(tc, host.IPAddress)
+	if errBlocked != nil {
+		return hostFilterState{}, errBlocked
+	}
+
+	return hostFilterState{
+		Name:    host.HostName,
+		MAC:     host.MACAddress,
+		IP:      host.IPAddress,
+		Blocked: blocked,
+	}, nil
+}
+
+func setBlocked(tc fritzbox.TR064Client, target string, blocked bool) (hostFilterState, error) {
+	host, errResolve := fritzbox.ResolveHost(tc, target)
+	if errResolve != nil {
+		return hostFilterState{}, errResolve
+	}
+
+	if errSet := fritzbox.SetInternetBlocked(tc, host.IPAddress, blocked); errSet != nil {
+		return hostFilterState{}, errSet
+	}
+	log.Info("Changed internet access of %s (%s) to blocked=%t", host.HostName, host.MACAddress, blocked)
+
+	return getBlocked(tc, target)
+}
+
+// Hosts may be given by name or MAC, the state is always published below the MAC like presence
+func publishHostFilterState(publishChan chan Message, topicPrefix string, state hostFilterState) {
+	payload, errMarshal := json.Marshal(state)
+	if errMarshal != nil {
+		log.Error("Could not marshal internet access of %s: %s", state.MAC, errMarshal)
+		return
+	}
+
+	publishChan <- Message{
+		Topic:    hostFilterStateTopic(topicPrefix, state.MAC),
+		Payload:  payload,
+		Retained: true,
+		Class:    ClassState,
+	}
+}
+
+func hostFilterStateTopic(topicPrefix string, mac string) string {
+	return fmt.Sprintf("%s/hostfilter/%s/state", topicPrefix, strings.ToLower(mac))
+}
+
+func parseBlocked(payload []byte) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(string(payload))) {
+	case "block":
+		return true, nil
+	case "unblock":
+		return false, nil
+	default:
+		return parseOnOff(payload)
+	}
+}
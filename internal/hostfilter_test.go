@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"errors"
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"strconv"
+	"testing"
+)
+
+// Answers like a FRITZ!Box without host list file, so hosts are read entry by entry
+type hostsClient struct {
+	fritzbox.TR064Client
+	hosts   []fritzbox.Host
+	blocked map[string]bool
+	woken   []string
+}
+
+func (c *hostsClient) Call(_ string, action string, arguments map[string]string) (map[string]string, error) {
+	switch action {
+	case "X_AVM-DE_GetHostListPath":
+		return nil, &fritzbox.TR064Error{Code: 401, Description: "Invalid Action"}
+	case "GetHostNumberOfEntries":
+		return map[string]string{"NewHostNumberOfEntries": strconv.Itoa(len(c.hosts))}, nil
+	case "GetGenericHostEntry":
+		index, _ := strconv.Atoi(arguments["NewIndex"])
+		host := c.hosts[index]
+		return map[string]string{"NewMACAddress": host.MACAddress, "NewIPAddress": host.IPAddress, "NewHostName": host.HostName, "NewActive": "1"}, nil
+	case "GetWANAccessByIP":
+		disallow := "0"
+		if c.blocked[arguments["NewIPv4Address"]] {
+			disallow = "1"
+		}
+		return map[string]string{"NewDisallow": disallow}, nil
+	case "DisallowWANAccessByIP":
+		c.blocked[arguments["NewIPv4Address"]] = arguments["NewDisallow"] == "1"
+		return nil, nil
+	case "X_AVM-DE_WakeOnLANByMACAddress":
+		c.woken = append(c.woken, arguments["NewMACAddress"])
+		return nil, nil
+	default:
+		return nil, errors.New("unexpected action " + action)
+	}
+}
+
+func newHostsClient() *hostsClient {
+	return &hostsClient{
+		hosts: []fritzbox.Host{
+			{MACAddress: "AA:BB:CC:DD:EE:01", IPAddress: "192.168.178.20", HostName: "phone"},
+			{MACAddress: "AA:BB:CC:DD:EE:02", IPAddress: "192.168.178.21", HostName: "laptop"},
+		},
+		blocked: map[string]bool{},
+	}
+}
+
+func Test_setBlocked(t *testing.T) {
+	tests := []struct {
+		target  string
+		mac     string
+		wantErr bool
+	}{
+		{"laptop", "AA:BB:CC:DD:EE:02", false},
+		{"Phone", "AA:BB:CC:DD:EE:01", false},
+		{"aa-bb-cc-dd-ee-02", "AA:BB:CC:DD:EE:02", false},
+		{"tablet", "", true},
+		// Known by MAC only, without an IPv4 address access can not be changed
+		{"AA:BB:CC:DD:EE:03", "", true},
+	}
+	for _, tt := range tests {
+		client := newHostsClient()
+		state, err := setBlocked(client, tt.target, true)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("setBlocked(%s) error = %v, wantErr %v", tt.target, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (state.MAC != tt.mac || !state.Blocked) {
+			t.Errorf("setBlocked(%s) = %+v, expected %s to be blocked", tt.target, state, tt.mac)
+		}
+	}
+}
+
+func Test_publishHostFilterState(t *testing.T) {
+	publishChan := make(chan Message, 1)
+	publishHostFilterState(publishChan, "fritze", hostFilterState{Name: "laptop", MAC: "AA:BB:CC:DD:EE:02", Blocked: true})
+
+	if message := <-publishChan; message.Topic != "fritze/hostfilter/aa:bb:cc:dd:ee:02/state" || !message.Retained {
+		t.Errorf("invalid state message %+v", message)
+	}
+}
+
+func Test_parseBlocked(t *testing.T) {
+	tests := []struct {
+		payload  string
+		expected bool
+		wantErr  bool
+	}{
+		{"block", true, false},
+		{" Unblock ", false, false},
+		{"on", true, false},
+		{"0", false, false},
+		{"maybe", false, true},
+	}
+	for _, tt := range tests {
+		got, err := parseBlocked([]byte(tt.payload))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseBlocked(%q) error = %v, wantErr %v", tt.payload, err, tt.wantErr)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("parseBlocked(%q) = %t, expected %t", tt.payload, got, tt.expected)
+		}
+	}
+}
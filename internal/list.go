@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/webishdev/fritze-mqtt/fritzbox"
 	"github.com/webishdev/fritze-mqtt/log"
+	"strings"
+	"time"
 )
 
 func ListDevices(fc fritzbox.FritzClient, username string, password string) error {
@@ -29,3 +31,38 @@ func ListDevices(fc fritzbox.FritzClient, username string, password string) erro
 
 	return nil
 }
+
+func ListServices(tc fritzbox.TR064Client) error {
+	log.SetLogLevel(10)
+	services, errServices := tc.Services()
+	if errServices != nil {
+		return errServices
+	}
+
+	for _, service := range services {
+		fmt.Printf("%s (%s)\n", service.ServiceType, service.ControlURL)
+		for _, action := range service.Actions {
+			fmt.Printf("  %s(%s) -> (%s)\n", action.Name, strings.Join(action.In, ", "), strings.Join(action.Out, ", "))
+		}
+	}
+
+	return nil
+}
+
+func ListBoxes(timeout time.Duration) error {
+	boxes, errDiscover := fritzbox.Discover(timeout)
+	if errDiscover != nil {
+		return errDiscover
+	}
+
+	if len(boxes) == 0 {
+		fmt.Println("No devices found")
+		return nil
+	}
+
+	for _, box := range boxes {
+		fmt.Printf("%s: %s, [%s]\n", box.BaseURL, box.Name, box.Model)
+	}
+
+	return nil
+}
@@ -1,42 +1,351 @@
 package internal
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/webishdev/fritze-mqtt/log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
-func StartMQTT(mqttChan chan byte, broker string, port int, topic string) error {
-	brokerURL := fmt.Sprintf("tcp://%s:%d", broker, port)
+type Message struct {
+	Topic    string
+	Payload  []byte
+	Retained bool
+	// Overrides Retained when a retention is configured for the class
+	Class TopicClass
+	// Only sent with MQTT 5
+	Expiry     time.Duration
+	Properties map[string]string
+}
+
+// Transient events are dropped by MQTT 5 brokers when nobody received them in time
+const eventExpiry = 5 * time.Minute
+
+type CommandHandler func(topic string, payload []byte)
+
+type Command struct {
+	Topic   string
+	Handler CommandHandler
+}
+
+type MQTTOptions struct {
+	URL     string
+	Headers http.Header
+	Broker  string
+	Port    int
+	Topic   string
+	// Empty when another connection owns the bridge status
+	StatusTopic string
+	// The Last Will marks this Homie device as lost instead of the bridge as offline, a connection has only one will
+	HomieStateTopic string
+	ClientID        string
+	Username        string
+	Password        string
+	TLS             bool
+	Insecure        bool
+	CAFile          string
+	CertFile        string
+	KeyFile         string
+	Retention       map[TopicClass]bool
+	// Messages are queued while the broker is unreachable
+	OutboxSize int
+	OutboxFile string
+	// MQTT 5 only
+	Version      int
+	TopicAliases uint16
+}
+
+// A full URL, e.g. wss://proxy/mqtt, takes precedence over broker and port
+func (o MQTTOptions) brokerURL() (*url.URL, error) {
+	if o.URL == "" {
+		scheme, port := "tcp", 1883
+		if o.TLS {
+			scheme, port = "ssl", 8883
+		}
+		if o.Port != 0 {
+			port = o.Port
+		}
+		return &url.URL{Scheme: scheme, Host: fmt.Sprintf("%s:%d", o.Broker, port)}, nil
+	}
+
+	u, err := url.Parse(o.URL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "tcp", "mqtt", "ws":
+		if o.TLS {
+			return nil, fmt.Errorf("TLS requires an ssl, mqtts or wss broker url, got %s", u.Redacted())
+		}
+	case "ssl", "tls", "mqtts", "wss":
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q in broker url %s", u.Scheme, u.Redacted())
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host in broker url %s", u.Redacted())
+	}
+	return u, nil
+}
+
+// Catches configuration errors before the bridge starts instead of on the first connect
+func (o MQTTOptions) Validate() error {
+	broker, err := o.brokerURL()
+	if err != nil {
+		return err
+	}
+	if usesTLS(broker) || o.CAFile != "" || o.CertFile != "" || o.KeyFile != "" {
+		if _, err := newMQTTTLSConfig(o); err != nil {
+			return err
+		}
+	}
+	if _, err := newOutbox(o.OutboxSize, o.OutboxFile); err != nil {
+		return fmt.Errorf("could not load outbox %s: %w", o.OutboxFile, err)
+	}
+	return nil
+}
+
+func (o MQTTOptions) will() (string, string) {
+	if o.HomieStateTopic != "" {
+		return o.HomieStateTopic, "lost"
+	}
+	return o.StatusTopic, Offline
+}
+
+// The will replaces $state on the broker, the last published one is restored after reconnecting
+type homieState struct {
+	mu      sync.Mutex
+	topic   string
+	payload []byte
+}
+
+func (s *homieState) record(message Message) {
+	if s.topic == "" || message.Topic != s.topic {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.payload = message.Payload
+}
+
+func (s *homieState) last() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.payload
+}
+
+func usesTLS(u *url.URL) bool {
+	switch u.Scheme {
+	case "ssl", "tls", "mqtts", "wss":
+		return true
+	default:
+		return false
+	}
+}
+
+func StartMQTT(mqttChan chan byte, options MQTTOptions, publishChan chan Message, commands []Command) error {
+	broker, errURL := options.brokerURL()
+	if errURL != nil {
+		return errURL
+	}
+	if options.Version == 5 {
+		return startMQTT5(mqttChan, options, broker, publishChan, commands)
+	}
+
+	brokerURL := broker.Redacted()
+	topic := options.Topic
+	statusTopic := options.StatusTopic
+	state := &homieState{topic: options.HomieStateTopic}
+	connectedChan := make(chan struct{}, 1)
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker(brokerURL)
-	opts.SetClientID("fritze-mqtt")
+	opts.AddBroker(broker.String())
+	// Bridges sharing a client ID kick each other off the broker
+	opts.SetClientID(options.ClientID)
+	if options.Username != "" {
+		opts.SetUsername(options.Username)
+		opts.SetPassword(options.Password)
+	}
+	if usesTLS(broker) {
+		tlsConfig, errTLS := newMQTTTLSConfig(options)
+		if errTLS != nil {
+			return errTLS
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+	// Sent with the WebSocket handshake, e.g. to authenticate with a reverse proxy
+	if len(options.Headers) > 0 {
+		opts.SetHTTPHeaders(options.Headers)
+	}
 	opts.SetDefaultPublishHandler(messagePubHandler)
-	//opts.SetUsername("fritze")
-	//opts.SetPassword("mq")
+	// The broker publishes the retained will when the bridge goes away without disconnecting
+	willTopic, willPayload := options.will()
+	opts.SetWill(willTopic, willPayload, 1, true)
+	// Subscriptions are gone after the broker restarted, they are set up again on every connect
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		log.Info("Successfully connected to MQTT broker at %s", brokerURL)
+		if statusTopic != "" {
+			client.Publish(statusTopic, 1, true, Online)
+		}
+		if payload := state.last(); payload != nil {
+			client.Publish(state.topic, 1, true, payload)
+		}
+		subscribe(client, topic, commands)
+		select {
+		case connectedChan <- struct{}{}:
+		default:
+		}
+	})
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		log.Warn("Lost connection to MQTT broker at %s: %s", brokerURL, err)
+	})
+	opts.SetAutoReconnect(true)
+	opts.SetMaxReconnectInterval(2 * time.Minute)
+	opts.SetConnectRetry(true)
+	opts.SetConnectRetryInterval(5 * time.Second)
 
-	client := mqtt.NewClient(opts)
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		return token.Error()
+	box, errOutbox := newOutbox(options.OutboxSize, options.OutboxFile)
+	if errOutbox != nil {
+		return errOutbox
 	}
 
-	log.Info("Successfully connected to MQTT broker at %s", brokerURL)
+	// With connect retry the first connect does not fail but keeps trying in the background
+	client := mqtt.NewClient(opts)
+	client.Connect()
+
+	runOutbox(mqttChan, publishChan, connectedChan, box, options.Retention, func(message Message) error {
+		if !client.IsConnectionOpen() {
+			return errNotConnected
+		}
+		publishToken := client.Publish(message.Topic, 1, message.Retained, message.Payload)
+		if !publishToken.WaitTimeout(10 * time.Second) {
+			return fmt.Errorf("publish timed out")
+		}
+		if publishToken.Error() != nil {
+			return publishToken.Error()
+		}
+		state.record(message)
+		return nil
+	})
 
+	// A clean disconnect does not trigger the will
+	if client.IsConnectionOpen() && statusTopic != "" {
+		client.Publish(statusTopic, 1, true, Offline).WaitTimeout(time.Second)
+	}
+	client.Disconnect(250)
+	log.Info("Disconnected from MQTT broker at %s", brokerURL)
+	return nil
+}
+
+func subscribe(client mqtt.Client, topic string, commands []Command) {
 	token := client.Subscribe(topic, 1, nil)
+	if token.Wait() && token.Error() != nil {
+		log.Error("Could not subscribe to topic %s: %s", topic, token.Error())
+	} else {
+		log.Info("Subscribed to topic %s", topic)
+	}
+
+	for _, command := range commands {
+		handler := command.Handler
+		commandToken := client.Subscribe(command.Topic, 1, func(client mqtt.Client, msg mqtt.Message) {
+			log.Debug("Received command %s from topic: %s", msg.Payload(), msg.Topic())
+			defer recoverPanic("handler of " + msg.Topic())
+			handler(msg.Topic(), msg.Payload())
+		})
+		if commandToken.Wait() && commandToken.Error() != nil {
+			log.Error("Could not subscribe to command topic %s: %s", command.Topic, commandToken.Error())
+			continue
+		}
+		log.Info("Subscribed to command topic %s", command.Topic)
+	}
+}
 
-	token.Wait()
-	log.Info("Subscribed to topic %s", topic)
+func newMQTTTLSConfig(options MQTTOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: options.Insecure,
+	}
 
-	select {
-	case <-mqttChan:
-		{
-			client.Disconnect(0)
-			log.Info("Disconnected from MQTT broker at %s", brokerURL)
-			return nil
+	if options.CAFile != "" {
+		pem, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", options.CAFile)
 		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if options.CertFile != "" || options.KeyFile != "" {
+		if options.CertFile == "" || options.KeyFile == "" {
+			return nil, fmt.Errorf("client certificate and key are both required")
+		}
+		certificate, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+// A crashing command handler must not take down the whole bridge
+func recoverPanic(name string) {
+	if r := recover(); r != nil {
+		log.Error("The %s crashed: %v", name, r)
+	}
+}
+
+// Slow TR-064 calls must not hold up the incoming messages, the handler works off its commands in order on its own
+func inBackground(handler CommandHandler) CommandHandler {
+	type request struct {
+		topic   string
+		payload []byte
+	}
+	requests := make(chan request, 10)
+	go func() {
+		for r := range requests {
+			func() {
+				defer recoverPanic("handler of " + r.topic)
+				handler(r.topic, r.payload)
+			}()
+		}
+	}()
+	return func(topic string, payload []byte) {
+		requests <- request{topic: topic, payload: payload}
 	}
 }
 
 var messagePubHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
 	log.Info("Received message: %s from topic: %s", msg.Payload(), msg.Topic())
 }
+
+func parseOnOff(payload []byte) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(string(payload))) {
+	case "1", "on", "true":
+		return true, nil
+	case "0", "off", "false":
+		return false, nil
+	default:
+		return false, fmt.Errorf("expected on or off")
+	}
+}
+
+func topicLevel(topic string, level int) string {
+	levels := strings.Split(topic, "/")
+	if level < 0 {
+		level = len(levels) + level
+	}
+	if level < 0 || level >= len(levels) {
+		return ""
+	}
+	return levels[level]
+}
@@ -0,0 +1,291 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/webishdev/fritze-mqtt/log"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Command acknowledgements are sent to the response topic of a request
+const commandAccepted = "accepted"
+
+// Topic aliases only live as long as a connection, they are assigned again after reconnecting.
+// Only the outbox applies them, a single publisher keeps each alias defined before it is used alone.
+type topicAliases struct {
+	mu      sync.Mutex
+	max     uint16
+	aliases map[string]uint16
+}
+
+func newTopicAliases() *topicAliases {
+	return &topicAliases{aliases: map[string]uint16{}}
+}
+
+func (t *topicAliases) reset(max uint16) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.max = max
+	t.aliases = map[string]uint16{}
+}
+
+// The first publish to a topic carries topic and alias, later ones only the alias
+func (t *topicAliases) apply(p *paho.Publish) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if p.Topic == "" || (p.Properties != nil && p.Properties.TopicAlias != nil) {
+		return
+	}
+	if p.Properties == nil {
+		p.Properties = &paho.PublishProperties{}
+	}
+
+	if alias, exists := t.aliases[p.Topic]; exists {
+		p.Properties.TopicAlias = paho.Uint16(alias)
+		p.Topic = ""
+		return
+	}
+
+	if len(t.aliases) < int(t.max) {
+		p.Properties.TopicAlias = paho.Uint16(uint16(len(t.aliases) + 1))
+	}
+}
+
+// An alias is only used alone once the broker got a publish carrying topic and alias
+func (t *topicAliases) confirm(p *paho.Publish) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if p.Topic == "" || p.Properties == nil || p.Properties.TopicAlias == nil {
+		return
+	}
+	if _, exists := t.aliases[p.Topic]; !exists && len(t.aliases) < int(t.max) {
+		t.aliases[p.Topic] = *p.Properties.TopicAlias
+	}
+}
+
+func startMQTT5(mqttChan chan byte, options MQTTOptions, broker *url.URL, publishChan chan Message, commands []Command) error {
+	brokerURL := broker.Redacted()
+	statusTopic := options.StatusTopic
+	aliases := newTopicAliases()
+	state := &homieState{topic: options.HomieStateTopic}
+	connectedChan := make(chan struct{}, 1)
+	willTopic, willPayload := options.will()
+
+	config := autopaho.ClientConfig{
+		ServerUrls:                    []*url.URL{broker},
+		KeepAlive:                     30,
+		CleanStartOnInitialConnection: true,
+		ReconnectBackoff:              autopaho.NewExponentialBackoff(time.Second, 2*time.Minute, 5*time.Second, 2),
+		ConnectUsername:               options.Username,
+		ConnectPassword:               []byte(options.Password),
+		WillMessage: &paho.WillMessage{
+			Topic:   willTopic,
+			Payload: []byte(willPayload),
+			QoS:     1,
+			Retain:  true,
+		},
+		OnConnectionUp: func(cm *autopaho.ConnectionManager, connack *paho.Connack) {
+			log.Info("Successfully connected to MQTT broker at %s", brokerURL)
+			var serverMax uint16
+			if connack.Properties != nil && connack.Properties.TopicAliasMaximum != nil {
+				serverMax = *connack.Properties.TopicAliasMaximum
+			}
+			aliases.reset(min(serverMax, options.TopicAliases))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if statusTopic != "" {
+				if _, err := cm.Publish(ctx, &paho.Publish{Topic: statusTopic, Payload: []byte(Online), QoS: 1, Retain: true}); err != nil {
+					log.Error("Could not publish to topic %s: %s", statusTopic, err)
+				}
+			}
+			if payload := state.last(); payload != nil {
+				if _, err := cm.Publish(ctx, &paho.Publish{Topic: state.topic, Payload: payload, QoS: 1, Retain: true}); err != nil {
+					log.Error("Could not publish to topic %s: %s", state.topic, err)
+				}
+			}
+
+			subscriptions := []paho.SubscribeOptions{{Topic: options.Topic, QoS: 1}}
+			for _, command := range commands {
+				subscriptions = append(subscriptions, paho.SubscribeOptions{Topic: command.Topic, QoS: 1})
+			}
+			if _, err := cm.Subscribe(ctx, &paho.Subscribe{Subscriptions: subscriptions}); err != nil {
+				log.Error("Could not subscribe to command topics: %s", err)
+				return
+			}
+			for _, subscription := range subscriptions {
+				log.Info("Subscribed to topic %s", subscription.Topic)
+			}
+
+			select {
+			case connectedChan <- struct{}{}:
+			default:
+			}
+		},
+		OnConnectError: func(err error) {
+			log.Error("Could not connect to MQTT broker at %s: %s", brokerURL, err)
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID: options.ClientID,
+			OnClientError: func(err error) {
+				log.Warn("Lost connection to MQTT broker at %s: %s", brokerURL, err)
+			},
+			OnPublishReceived: []func(paho.PublishReceived) (bool, error){
+				func(received paho.PublishReceived) (bool, error) {
+					// Commands are handled in the order they arrive, slow handlers run in the background themselves
+					handleCommand5(received, options.Topic, commands)
+					return true, nil
+				},
+			},
+		},
+	}
+
+	if usesTLS(broker) {
+		tlsConfig, errTLS := newMQTTTLSConfig(options)
+		if errTLS != nil {
+			return errTLS
+		}
+		config.TlsCfg = tlsConfig
+	}
+	// Sent with the WebSocket handshake, e.g. to authenticate with a reverse proxy
+	if len(options.Headers) > 0 {
+		config.WebSocketCfg = &autopaho.WebSocketConfig{
+			Header: func(*url.URL, *tls.Config) http.Header {
+				return options.Headers
+			},
+		}
+	}
+
+	box, errOutbox := newOutbox(options.OutboxSize, options.OutboxFile)
+	if errOutbox != nil {
+		return errOutbox
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Connects and reconnects in the background
+	cm, err := autopaho.NewConnection(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	runOutbox(mqttChan, publishChan, connectedChan, box, options.Retention, func(message Message) error {
+		publishCtx, publishCancel := context.WithTimeout(ctx, 10*time.Second)
+		defer publishCancel()
+		publish := newPublish5(message)
+		aliases.apply(publish)
+		response, errPublish := cm.Publish(publishCtx, publish)
+		if errors.Is(errPublish, autopaho.ConnectionDownError) {
+			return errNotConnected
+		}
+		if errors.Is(errPublish, paho.ErrInvalidArguments) || (response != nil && response.ReasonCode >= 0x80) {
+			return fmt.Errorf("%w: %s", errRejected, errPublish)
+		}
+		if errPublish != nil {
+			return errPublish
+		}
+		aliases.confirm(publish)
+		state.record(message)
+		return nil
+	})
+
+	// A clean disconnect does not trigger the will
+	if statusTopic != "" {
+		offlineCtx, offlineCancel := context.WithTimeout(ctx, time.Second)
+		_, _ = cm.Publish(offlineCtx, &paho.Publish{Topic: statusTopic, Payload: []byte(Offline), QoS: 1, Retain: true})
+		offlineCancel()
+	}
+	disconnectCtx, disconnectCancel := context.WithTimeout(ctx, time.Second)
+	_ = cm.Disconnect(disconnectCtx)
+	disconnectCancel()
+	log.Info("Disconnected from MQTT broker at %s", brokerURL)
+	return nil
+}
+
+func newPublish5(message Message) *paho.Publish {
+	publish := &paho.Publish{
+		Topic:   message.Topic,
+		Payload: message.Payload,
+		QoS:     1,
+		Retain:  message.Retained,
+	}
+	if message.Expiry > 0 || len(message.Properties) > 0 {
+		publish.Properties = &paho.PublishProperties{}
+	}
+	if message.Expiry > 0 {
+		publish.Properties.MessageExpiry = paho.Uint32(uint32(message.Expiry.Seconds()))
+	}
+	for _, key := range slices.Sorted(maps.Keys(message.Properties)) {
+		publish.Properties.User.Add(key, message.Properties[key])
+	}
+	return publish
+}
+
+func handleCommand5(received paho.PublishReceived, topic string, commands []Command) {
+	packet := received.Packet
+	log.Debug("Received command %s from topic: %s", packet.Payload, packet.Topic)
+	defer recoverPanic("handler of " + packet.Topic)
+
+	handled := false
+	for _, command := range commands {
+		if topicMatches(command.Topic, packet.Topic) {
+			command.Handler(packet.Topic, packet.Payload)
+			handled = true
+		}
+	}
+	if !handled {
+		if topicMatches(topic, packet.Topic) {
+			log.Info("Received message: %s from topic: %s", packet.Payload, packet.Topic)
+		}
+		return
+	}
+
+	// Requests carrying a response topic are acknowledged with their correlation data
+	if packet.Properties == nil || packet.Properties.ResponseTopic == "" {
+		return
+	}
+	response := &paho.Publish{
+		Topic:   packet.Properties.ResponseTopic,
+		Payload: []byte(commandAccepted),
+		QoS:     1,
+		Properties: &paho.PublishProperties{
+			CorrelationData: packet.Properties.CorrelationData,
+		},
+	}
+	// Waiting for the broker here would block the incoming messages
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if _, err := received.Client.Publish(ctx, response); err != nil {
+			log.Error("Could not publish to topic %s: %s", response.Topic, err)
+		}
+	}()
+}
+
+// Matches a topic against a subscription filter with + and # wildcards
+func topicMatches(filter string, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) || (level != "+" && level != topicLevels[i]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
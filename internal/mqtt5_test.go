@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"github.com/eclipse/paho.golang/paho"
+	"testing"
+	"time"
+)
+
+func Test_topicAliases(t *testing.T) {
+	aliases := newTopicAliases()
+	aliases.reset(1)
+
+	first := &paho.Publish{Topic: "fritze/116570240192/state"}
+	aliases.apply(first)
+	if first.Topic == "" || first.Properties.TopicAlias == nil || *first.Properties.TopicAlias != 1 {
+		t.Errorf("first publish must carry topic and alias, got %+v", first)
+	}
+
+	failed := &paho.Publish{Topic: "fritze/116570240192/state"}
+	aliases.apply(failed)
+	if failed.Topic == "" {
+		t.Errorf("the alias must not be used alone before a publish got through, got %+v", failed)
+	}
+	aliases.confirm(first)
+
+	second := &paho.Publish{Topic: "fritze/116570240192/state"}
+	aliases.apply(second)
+	if second.Topic != "" || *second.Properties.TopicAlias != 1 {
+		t.Errorf("second publish must only carry the alias, got %+v", second)
+	}
+
+	other := &paho.Publish{Topic: "fritze/116570240192/power"}
+	aliases.apply(other)
+	aliases.confirm(other)
+	if other.Topic == "" || other.Properties.TopicAlias != nil {
+		t.Errorf("no alias left for another topic, got %+v", other)
+	}
+
+	aliases.reset(1)
+	reconnected := &paho.Publish{Topic: "fritze/116570240192/state"}
+	aliases.apply(reconnected)
+	if reconnected.Topic == "" || *reconnected.Properties.TopicAlias != 1 {
+		t.Error("aliases must be assigned again after reconnecting")
+	}
+}
+
+func Test_newPublish5(t *testing.T) {
+	publish := newPublish5(Message{
+		Topic:      "fritze/116570240192/event",
+		Payload:    []byte("button_1_short"),
+		Expiry:     eventExpiry,
+		Properties: map[string]string{"product": "FRITZ!DECT 440", "type": "button", "ain": "11657 0240192"},
+	})
+
+	if *publish.Properties.MessageExpiry != uint32((5 * time.Minute).Seconds()) {
+		t.Errorf("invalid expiry %d", *publish.Properties.MessageExpiry)
+	}
+	if publish.Properties.User.Get("ain") != "11657 0240192" || publish.Properties.User[0].Key != "ain" {
+		t.Errorf("invalid user properties %v", publish.Properties.User)
+	}
+
+	if newPublish5(Message{Topic: "fritze/bridge/status"}).Properties != nil {
+		t.Error("properties without expiry and user properties")
+	}
+}
+
+func Test_topicMatches(t *testing.T) {
+	tests := []struct {
+		filter   string
+		topic    string
+		expected bool
+	}{
+		{"fritze/+/set", "fritze/116570240192/set", true},
+		{"fritze/+/set", "fritze/116570240192/level/set", false},
+		{"fritze/#", "fritze/wlan/guest/set", true},
+		{"fritze/wol/wake", "fritze/wol/wake", true},
+		{"fritze/wol/wake", "fritze/wol", false},
+	}
+	for _, tt := range tests {
+		if got := topicMatches(tt.filter, tt.topic); got != tt.expected {
+			t.Errorf("topicMatches(%s, %s) = %t, expected %t", tt.filter, tt.topic, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fritze-mqtt"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func Test_newMQTTTLSConfig(t *testing.T) {
+	certFile, keyFile := writeCertificate(t, t.TempDir())
+
+	tlsConfig, err := newMQTTTLSConfig(MQTTOptions{TLS: true, CAFile: certFile, CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.RootCAs == nil || len(tlsConfig.Certificates) != 1 {
+		t.Errorf("CA or client certificate missing")
+	}
+
+	if _, err := newMQTTTLSConfig(MQTTOptions{TLS: true, CertFile: certFile}); err == nil {
+		t.Error("client certificate without key accepted")
+	}
+
+	if _, err := newMQTTTLSConfig(MQTTOptions{TLS: true, CAFile: keyFile}); err == nil {
+		t.Error("CA file without certificates accepted")
+	}
+}
+
+func Test_brokerURL(t *testing.T) {
+	tests := []struct {
+		options  MQTTOptions
+		expected string
+		wantErr  bool
+	}{
+		{MQTTOptions{Broker: "localhost"}, "tcp://localhost:1883", false},
+		{MQTTOptions{Broker: "localhost", TLS: true}, "ssl://localhost:8883", false},
+		{MQTTOptions{Broker: "broker", Port: 8884, TLS: true}, "ssl://broker:8884", false},
+		{MQTTOptions{Broker: "ignored", URL: "wss://proxy.example.com/mqtt"}, "wss://proxy.example.com/mqtt", false},
+		{MQTTOptions{URL: "ws://proxy:8080/mqtt"}, "ws://proxy:8080/mqtt", false},
+		{MQTTOptions{URL: "ws://proxy:8080/mqtt", TLS: true}, "", true},
+		{MQTTOptions{URL: "http://proxy/mqtt"}, "", true},
+	}
+	for _, tt := range tests {
+		got, err := tt.options.brokerURL()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("brokerURL() error = %v, wantErr %v", err, tt.wantErr)
+			continue
+		}
+		if err == nil && got.String() != tt.expected {
+			t.Errorf("brokerURL() = %s, expected %s", got, tt.expected)
+		}
+	}
+}
+
+func Test_will(t *testing.T) {
+	topic, payload := MQTTOptions{StatusTopic: "fritz/bridge/status"}.will()
+	if topic != "fritz/bridge/status" || payload != Offline {
+		t.Errorf("will() = %s %s, expected the offline status", topic, payload)
+	}
+
+	topic, payload = MQTTOptions{StatusTopic: "fritz/bridge/status", HomieStateTopic: "homie/5/fritzbox/$state"}.will()
+	if topic != "homie/5/fritzbox/$state" || payload != "lost" {
+		t.Errorf("will() = %s %s, expected the Homie device to be lost", topic, payload)
+	}
+
+	state := &homieState{topic: "homie/5/fritzbox/$state"}
+	state.record(Message{Topic: "homie/5/fritzbox/$state", Payload: []byte("ready")})
+	state.record(Message{Topic: "homie/5/fritzbox/main/state", Payload: []byte("true")})
+	if string(state.last()) != "ready" {
+		t.Errorf("expected ready to be restored after reconnecting, got %s", state.last())
+	}
+}
+
+func Test_validate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir)
+	brokenOutbox := filepath.Join(dir, "outbox.json")
+	if err := os.WriteFile(brokenOutbox, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		options MQTTOptions
+		wantErr bool
+	}{
+		{MQTTOptions{Broker: "localhost"}, false},
+		{MQTTOptions{Broker: "localhost", TLS: true, CAFile: certFile, CertFile: certFile, KeyFile: keyFile}, false},
+		{MQTTOptions{URL: "http://proxy/mqtt"}, true},
+		{MQTTOptions{Broker: "localhost", TLS: true, CAFile: filepath.Join(dir, "missing.pem")}, true},
+		{MQTTOptions{Broker: "localhost", CertFile: certFile}, true},
+		{MQTTOptions{Broker: "localhost", OutboxFile: brokenOutbox}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.options.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+		}
+	}
+}
+
+func Test_inBackground(t *testing.T) {
+	received := make(chan string, 3)
+	handler := inBackground(func(topic string, payload []byte) {
+		if string(payload) == "crash" {
+			panic("broken handler")
+		}
+		received <- string(payload)
+	})
+	handler("fritze/wlan/1/set", []byte("on"))
+	handler("fritze/wlan/1/set", []byte("crash"))
+	handler("fritze/wlan/1/set", []byte("off"))
+
+	if first, second := <-received, <-received; first != "on" || second != "off" {
+		t.Errorf("expected the commands in order, got %s %s", first, second)
+	}
+}
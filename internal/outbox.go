@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+var errNotConnected = errors.New("not connected to the MQTT broker")
+
+// Publishing the message again will not help, e.g. the broker refused it
+var errRejected = errors.New("rejected by the MQTT broker")
+
+// A message failing for other reasons is dropped after so many attempts, so it does not hold up the others
+const maxPublishAttempts = 5
+
+// Holds messages while the broker is unreachable, they are published in order once it is back
+type outbox struct {
+	size     int
+	file     string
+	messages []Message
+	// Failed attempts to publish the first message
+	attempts int
+	// Whether the file differs from the messages, or still holds messages
+	dirty  bool
+	stored bool
+}
+
+// Messages of a previous run are loaded from the file, if there is one
+func newOutbox(size int, file string) (*outbox, error) {
+	o := &outbox{size: size, file: file}
+	if file == "" {
+		return o, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &o.messages); err != nil {
+		return nil, err
+	}
+	if len(o.messages) > 0 {
+		log.Info("Loaded %d queued messages from %s", len(o.messages), file)
+		o.stored = true
+	}
+	o.trim()
+	return o, nil
+}
+
+// Only the latest message for a topic is kept
+func (o *outbox) push(message Message) {
+	o.messages = slices.DeleteFunc(o.messages, func(queued Message) bool {
+		return queued.Topic == message.Topic
+	})
+	o.messages = append(o.messages, message)
+	o.trim()
+	o.dirty = true
+}
+
+// The oldest messages are dropped when the outbox is full
+func (o *outbox) trim() {
+	if o.size <= 0 || len(o.messages) <= o.size {
+		return
+	}
+	dropped := len(o.messages) - o.size
+	log.Warn("Outbox is full, dropping %d oldest messages", dropped)
+	o.messages = o.messages[dropped:]
+}
+
+// Publishes until the first failure, the failed message stays first in line unless it can not be published at all
+func (o *outbox) flush(publish func(Message) error) {
+	sent, dropped := 0, 0
+	for len(o.messages) > 0 {
+		message := o.messages[0]
+		err := publishChecked(message, publish)
+		if errors.Is(err, errNotConnected) {
+			break
+		}
+		if err != nil {
+			o.attempts++
+			if !errors.Is(err, errRejected) && o.attempts < maxPublishAttempts {
+				log.Error("Could not publish to topic %s: %s", message.Topic, err)
+				break
+			}
+			log.Error("Dropping message to topic %s: %s", message.Topic, err)
+			dropped++
+		} else {
+			sent++
+		}
+		o.attempts = 0
+		o.messages = o.messages[1:]
+	}
+	if sent == 0 && dropped == 0 {
+		return
+	}
+	o.dirty = true
+	if len(o.messages) == 0 && sent > 1 {
+		log.Info("Published %d queued messages", sent)
+	}
+}
+
+// Messages which are not queued are only published when nothing is waiting in line
+func (o *outbox) send(message Message, publish func(Message) error) {
+	if len(o.messages) > 0 {
+		log.Warn("Dropping message to topic %s while the broker is unreachable", message.Topic)
+		return
+	}
+	if err := publishChecked(message, publish); err != nil && !errors.Is(err, errNotConnected) {
+		log.Error("Could not publish to topic %s: %s", message.Topic, err)
+	}
+}
+
+func publishChecked(message Message, publish func(Message) error) error {
+	if message.Topic == "" || strings.ContainsAny(message.Topic, "+#") {
+		return fmt.Errorf("%w: invalid topic", errRejected)
+	}
+	return publish(message)
+}
+
+// Written while messages are left behind and once more when the outbox is empty again
+func (o *outbox) persist() {
+	if o.file == "" || !o.dirty {
+		return
+	}
+	o.dirty = false
+	if len(o.messages) == 0 && !o.stored {
+		return
+	}
+	data, err := json.Marshal(o.messages)
+	if err != nil {
+		log.Error("Could not save outbox: %s", err)
+		return
+	}
+	tmp := o.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log.Error("Could not save outbox: %s", err)
+		return
+	}
+	if err := os.Rename(tmp, o.file); err != nil {
+		log.Error("Could not save outbox: %s", err)
+		return
+	}
+	o.stored = len(o.messages) > 0
+}
+
+// States and clears are queued, events and replies are stale by the time the broker is back
+func queued(message Message) bool {
+	switch message.Class {
+	case ClassEvent:
+		return false
+	case "":
+		return message.Retained
+	default:
+		return true
+	}
+}
+
+// Queues states and publishes whenever the broker is connected, until torn down
+func runOutbox(mqttChan chan byte, publishChan chan Message, connectedChan chan struct{}, box *outbox, retention map[TopicClass]bool, publish func(Message) error) {
+	push := func(message Message) {
+		queue := queued(message)
+		if retained, exists := retention[message.Class]; exists && message.Class != "" {
+			message.Retained = retained
+		}
+		if !queue {
+			box.flush(publish)
+			box.send(message, publish)
+			return
+		}
+		box.push(message)
+		box.flush(publish)
+	}
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case message := <-publishChan:
+			push(message)
+		case <-connectedChan:
+			box.flush(publish)
+		case <-ticker.C:
+			box.flush(publish)
+			box.persist()
+		case <-mqttChan:
+			// The producers are stopped before, their last messages are still in the channel
+			for {
+				select {
+				case message := <-publishChan:
+					push(message)
+				default:
+					box.persist()
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func Test_outbox(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "outbox.json")
+
+	box, err := newOutbox(2, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	disconnected := func(Message) error { return errNotConnected }
+	box.push(Message{Topic: "fritze/1/state", Payload: []byte("1")})
+	box.push(Message{Topic: "fritze/1/state", Payload: []byte("0")})
+	box.push(Message{Topic: "fritze/2/state", Payload: []byte("1"), Retained: true})
+	box.flush(disconnected)
+	box.persist()
+
+	if len(box.messages) != 2 || string(box.messages[0].Payload) != "0" {
+		t.Fatalf("expected the oldest message to be dropped, got %v", box.messages)
+	}
+
+	reloaded, err := newOutbox(2, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.messages) != 2 || !reloaded.messages[1].Retained {
+		t.Fatalf("queued messages were not restored, got %v", reloaded.messages)
+	}
+
+	var published []string
+	failing := true
+	reloaded.flush(func(message Message) error {
+		if failing && message.Topic == "fritze/2/state" {
+			return errors.New("broken pipe")
+		}
+		published = append(published, message.Topic)
+		return nil
+	})
+	if len(reloaded.messages) != 1 || len(published) != 1 {
+		t.Fatalf("expected the failed message to stay queued, got %v", reloaded.messages)
+	}
+
+	failing = false
+	reloaded.flush(func(message Message) error {
+		published = append(published, message.Topic)
+		return nil
+	})
+	if len(reloaded.messages) != 0 || published[0] != "fritze/1/state" || published[1] != "fritze/2/state" {
+		t.Errorf("expected messages in order, got %v", published)
+	}
+
+	reloaded.persist()
+	emptied, err := newOutbox(2, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emptied.messages) != 0 {
+		t.Errorf("expected the published messages to be removed from the file, got %v", emptied.messages)
+	}
+}
+
+func Test_outboxCollapsesRetained(t *testing.T) {
+	box, err := newOutbox(0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	box.push(Message{Topic: "fritze/1/state", Payload: []byte("1"), Retained: true})
+	box.push(Message{Topic: "fritze/2/state", Payload: []byte("1"), Retained: true})
+	box.push(Message{Topic: "fritze/1/state", Payload: []byte("0"), Retained: true})
+
+	if len(box.messages) != 2 || box.messages[0].Topic != "fritze/2/state" || string(box.messages[1].Payload) != "0" {
+		t.Errorf("expected only the latest retained message per topic, got %v", box.messages)
+	}
+}
+
+func Test_outboxDropsFailing(t *testing.T) {
+	box, err := newOutbox(0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	box.push(Message{Topic: "fritze/+/state", Payload: []byte("1")})
+	box.push(Message{Topic: "fritze/1/state", Payload: []byte("1")})
+	box.push(Message{Topic: "fritze/2/state", Payload: []byte("1")})
+
+	var published []string
+	publish := func(message Message) error {
+		if message.Topic == "fritze/1/state" {
+			return errors.New("timed out")
+		}
+		published = append(published, message.Topic)
+		return nil
+	}
+	for range maxPublishAttempts - 1 {
+		box.flush(publish)
+	}
+	if len(box.messages) != 2 || len(published) != 0 {
+		t.Fatalf("expected the invalid topic to be dropped and the failing message to be retried, got %v", box.messages)
+	}
+
+	box.flush(publish)
+	if len(box.messages) != 0 || len(published) != 1 || published[0] != "fritze/2/state" {
+		t.Errorf("expected the failing message to be dropped after %d attempts, got %v", maxPublishAttempts, published)
+	}
+}
+
+func Test_runOutbox(t *testing.T) {
+	box, err := newOutbox(0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publishChan := make(chan Message, 4)
+	publishChan <- Message{Topic: "fritze/1/state", Payload: []byte("1"), Retained: true, Class: ClassState}
+	publishChan <- Message{Topic: "fritze/1/event", Payload: []byte("button_1_short"), Retained: true, Class: ClassEvent}
+	publishChan <- Message{Topic: "fritze/bridge/devices/response", Payload: []byte("[]")}
+	publishChan <- Message{Topic: "fritze/2/state", Retained: true}
+	mqttChan := make(chan byte, 1)
+	mqttChan <- 1
+
+	runOutbox(mqttChan, publishChan, make(chan struct{}), box, map[TopicClass]bool{ClassState: false}, func(Message) error {
+		return errNotConnected
+	})
+
+	if len(box.messages) != 2 || box.messages[0].Topic != "fritze/1/state" || box.messages[0].Retained || box.messages[1].Topic != "fritze/2/state" {
+		t.Errorf("expected only the state and the clear to be queued, got %v", box.messages)
+	}
+}
@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"github.com/webishdev/fritze-mqtt/log"
+	"strings"
+	"time"
+)
+
+type presenceState struct {
+	home      bool
+	active    bool
+	since     time.Time
+	published bool
+}
+
+type presenceTracker struct {
+	homeGrace time.Duration
+	awayGrace time.Duration
+	states    map[string]*presenceState
+}
+
+func newPresenceTracker(homeGrace time.Duration, awayGrace time.Duration, macs []string) *presenceTracker {
+	pt := &presenceTracker{
+		homeGrace: homeGrace,
+		awayGrace: awayGrace,
+		states:    map[string]*presenceState{},
+	}
+	for _, mac := range macs {
+		pt.states[fritzbox.NormalizeMAC(mac)] = &presenceState{}
+	}
+	return pt
+}
+
+// Returns the MAC addresses whose presence changed, a host has to stay active or inactive
+// for the grace period before it is reported as home or away
+func (pt *presenceTracker) update(now time.Time, active map[string]bool, trackAll bool) []string {
+	if trackAll {
+		for mac := range active {
+			if _, exists := pt.states[mac]; !exists {
+				pt.states[mac] = &presenceState{}
+			}
+		}
+	}
+
+	var changed []string
+	for mac, state := range pt.states {
+		isActive := active[mac]
+		if !state.published {
+			state.home = isActive
+			state.active = isActive
+			state.since = now
+			state.published = true
+			changed = append(changed, mac)
+			continue
+		}
+
+		if isActive != state.active {
+			state.active = isActive
+			state.since = now
+		}
+
+		grace := pt.awayGrace
+		if state.active {
+			grace = pt.homeGrace
+		}
+
+		if state.home != state.active && now.Sub(state.since) >= grace {
+			state.home = state.active
+			changed = append(changed, mac)
+		}
+	}
+
+	return changed
+}
+
+func StartPresence(presenceChan chan byte, tc fritzbox.TR064Client, publishChan chan Message, topicPrefix string, interval time.Duration, homeGrace time.Duration, awayGrace time.Duration, macs []string) error {
+	tracker := newPresenceTracker(homeGrace, awayGrace, macs)
+	trackAll := len(macs) == 0
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		hosts, errHosts := fritzbox.GetHosts(tc)
+		if errHosts != nil {
+			log.Error("Could not read host table: %s", errHosts)
+		} else {
+			macToHost := map[string]fritzbox.Host{}
+			active := map[string]bool{}
+			for _, host := range hosts {
+				mac := fritzbox.NormalizeMAC(host.MACAddress)
+				if mac == "" {
+					continue
+				}
+				macToHost[mac] = host
+				active[mac] = active[mac] || host.Active
+			}
+
+			for _, mac := range tracker.update(time.Now(), active, trackAll) {
+				publishPresence(publishChan, topicPrefix, mac, tracker.states[mac].home, macToHost[mac])
+			}
+		}
+
+		select {
+		case <-presenceChan:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func publishPresence(publishChan chan Message, topicPrefix string, mac string, home bool, host fritzbox.Host) {
+	state := "away"
+	if home {
+		state = "home"
+	}
+	log.Info("Host %s (%s) is %s", mac, host.HostName, state)
+
+	topic := fmt.Sprintf("%s/presence/%s", topicPrefix, strings.ToLower(mac))
+	publishChan <- Message{
+		Topic:    topic,
+		Payload:  []byte(state),
+		Retained: true,
+		Class:    ClassState,
+	}
+
+	if host.MACAddress == "" {
+		return
+	}
+
+	attributes, errMarshal := json.Marshal(host)
+	if errMarshal != nil {
+		log.Error("Could not marshal host %s: %s", mac, errMarshal)
+		return
+	}
+
+	publishChan <- Message{
+		Topic:    topic + "/attributes",
+		Payload:  attributes,
+		Retained: true,
+		Class:    ClassState,
+	}
+}
@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_presenceTracker(t *testing.T) {
+	mac := "AA:BB:CC:DD:EE:01"
+	tracker := newPresenceTracker(0, 5*time.Minute, []string{"aa-bb-cc-dd-ee-01"})
+	start := time.Now()
+
+	changed := tracker.update(start, map[string]bool{mac: true}, false)
+	if len(changed) != 1 || !tracker.states[mac].home {
+		t.Fatalf("expected initial home state, got %v", changed)
+	}
+
+	changed = tracker.update(start.Add(time.Minute), map[string]bool{}, false)
+	if len(changed) != 0 || !tracker.states[mac].home {
+		t.Error("host reported away within grace period")
+	}
+
+	changed = tracker.update(start.Add(3*time.Minute), map[string]bool{mac: true}, false)
+	if len(changed) != 0 {
+		t.Error("short absence reported")
+	}
+
+	tracker.update(start.Add(4*time.Minute), map[string]bool{}, false)
+	changed = tracker.update(start.Add(9*time.Minute), map[string]bool{}, false)
+	if len(changed) != 1 || tracker.states[mac].home {
+		t.Error("host not reported away after grace period")
+	}
+
+	changed = tracker.update(start.Add(10*time.Minute), map[string]bool{"AA:BB:CC:DD:EE:02": true}, false)
+	if len(changed) != 0 || len(tracker.states) != 1 {
+		t.Error("untracked host reported")
+	}
+}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/webishdev/fritze-mqtt/log"
+	"os"
+)
+
+type TopicClass string
+
+// Retention is configured per class, messages without a class keep their retained flag
+const (
+	ClassState     TopicClass = "state"
+	ClassEvent     TopicClass = "event"
+	ClassDiscovery TopicClass = "discovery"
+)
+
+// Remembers the topics published for each device, so they can be cleared once the device is gone
+type retainedTopics struct {
+	stateFile string
+	devices   map[string]map[string]string
+	stale     map[string]map[string]string
+	dirty     bool
+}
+
+// Topics of a previous run are loaded from the state file and cleared if their device did not come back
+func newRetainedTopics(stateFile string) *retainedTopics {
+	r := &retainedTopics{
+		stateFile: stateFile,
+		devices:   map[string]map[string]string{},
+	}
+	if stateFile == "" {
+		return r
+	}
+
+	content, err := os.ReadFile(stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return r
+	}
+	if err != nil {
+		log.Error("Could not read retained topics from %s: %s", stateFile, err)
+		return r
+	}
+	if errUnmarshal := json.Unmarshal(content, &r.stale); errUnmarshal != nil {
+		log.Error("Could not read retained topics from %s: %s", stateFile, errUnmarshal)
+	}
+	return r
+}
+
+// A key, e.g. the capability, is published on one topic, an old topic is cleared when it changes
+func (r *retainedTopics) track(publishChan chan Message, identifier string, key string, topic string) {
+	topics, exists := r.devices[identifier]
+	if !exists {
+		topics = map[string]string{}
+		r.devices[identifier] = topics
+	}
+	if previous, exists := topics[key]; exists {
+		if previous == topic {
+			return
+		}
+		clearTopic(publishChan, previous)
+	}
+	topics[key] = topic
+	r.dirty = true
+}
+
+func (r *retainedTopics) remove(publishChan chan Message, identifier string) {
+	for _, topic := range r.devices[identifier] {
+		clearTopic(publishChan, topic)
+	}
+	delete(r.devices, identifier)
+	r.dirty = true
+}
+
+// Called with the devices of the first poll, topics of devices which came back are taken over
+func (r *retainedTopics) clearStale(publishChan chan Message, current map[string]bool) {
+	for identifier, topics := range r.stale {
+		if current[identifier] {
+			for key, topic := range topics {
+				r.track(publishChan, identifier, key, topic)
+			}
+			continue
+		}
+		log.Info("Clearing %d retained topics of removed device %s", len(topics), identifier)
+		for _, topic := range topics {
+			clearTopic(publishChan, topic)
+		}
+		r.dirty = true
+	}
+	r.stale = nil
+}
+
+func (r *retainedTopics) save() {
+	if r.stateFile == "" || !r.dirty {
+		return
+	}
+	content, err := json.Marshal(r.devices)
+	if err != nil {
+		log.Error("Could not save retained topics: %s", err)
+		return
+	}
+	if errWrite := os.WriteFile(r.stateFile, content, 0600); errWrite != nil {
+		log.Error("Could not save retained topics: %s", errWrite)
+		return
+	}
+	r.dirty = false
+}
+
+// An empty retained message removes the retained message of a topic
+func clearTopic(publishChan chan Message, topic string) {
+	publishChan <- Message{Topic: topic, Retained: true}
+}
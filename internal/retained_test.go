@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func Test_retainedTopics(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "retained.json")
+	publishChan := make(chan Message, 100)
+
+	retained := newRetainedTopics(stateFile)
+	retained.clearStale(publishChan, map[string]bool{})
+	retained.track(publishChan, "11657 0240192", "state", "fritze/116570240192/state")
+	retained.track(publishChan, "11657 0240192", "power", "fritze/116570240192/power")
+	retained.track(publishChan, "12345 0000001", "state", "home/Kitchen/state")
+	retained.save()
+	if messages := drainMessages(publishChan); len(messages) != 0 {
+		t.Fatalf("nothing to clear yet, got %v", messages)
+	}
+
+	// A renamed device is published on a new topic when the template uses its name
+	retained.track(publishChan, "12345 0000001", "state", "home/Kitchen light/state")
+	if messages := drainMessages(publishChan); len(messages) != 1 || messages["home/Kitchen/state"] != "" {
+		t.Fatalf("old topic not cleared, got %v", messages)
+	}
+	retained.save()
+
+	restarted := newRetainedTopics(stateFile)
+	restarted.clearStale(publishChan, map[string]bool{"12345 0000001": true})
+	messages := drainMessages(publishChan)
+	if len(messages) != 2 {
+		t.Fatalf("expected the topics of the removed device to be cleared, got %v", messages)
+	}
+	if _, exists := messages["fritze/116570240192/power"]; !exists {
+		t.Errorf("power topic not cleared, got %v", messages)
+	}
+
+	restarted.remove(publishChan, "12345 0000001")
+	if messages := drainMessages(publishChan); len(messages) != 1 {
+		t.Errorf("expected the topic of the device that came back to be cleared, got %v", messages)
+	}
+}
@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"github.com/webishdev/fritze-mqtt/log"
+	"time"
+)
+
+type systemEvent struct {
+	Type           string    `json:"type"`
+	Time           time.Time `json:"time"`
+	Uptime         uint64    `json:"uptime"`
+	PreviousUptime uint64    `json:"previousUptime"`
+}
+
+func StartSystem(systemChan chan byte, tc fritzbox.TR064Client, publishChan chan Message, topicPrefix string, interval time.Duration, reloginChan chan byte) error {
+	var previous *fritzbox.SystemInfo
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		info, errInfo := fritzbox.GetSystemInfo(tc)
+		if errInfo != nil {
+			log.Error("Could not read system info: %s", errInfo)
+		} else {
+			if previous != nil && info.Uptime < previous.Uptime {
+				log.Warn("FRITZ!Box rebooted, uptime went from %ds to %ds", previous.Uptime, info.Uptime)
+				publishSystemEvent(publishChan, topicPrefix, systemEvent{
+					Type:           "reboot",
+					Time:           time.Now(),
+					Uptime:         info.Uptime,
+					PreviousUptime: previous.Uptime,
+				})
+				tc.Reset()
+				select {
+				case reloginChan <- 1:
+				default:
+				}
+			}
+			if previous != nil && info.SoftwareVersion != previous.SoftwareVersion {
+				log.Info("FRITZ!Box firmware changed from %s to %s", previous.SoftwareVersion, info.SoftwareVersion)
+			}
+			previous = &info
+			publishSystemInfo(publishChan, topicPrefix, info)
+		}
+
+		select {
+		case <-systemChan:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func publishSystemInfo(publishChan chan Message, topicPrefix string, info fritzbox.SystemInfo) {
+	payload, errMarshal := json.Marshal(info)
+	if errMarshal != nil {
+		log.Error("Could not marshal system info: %s", errMarshal)
+		return
+	}
+
+	publishChan <- Message{
+		Topic:    fmt.Sprintf("%s/system/state", topicPrefix),
+		Payload:  payload,
+		Retained: true,
+		Class:    ClassState,
+	}
+}
+
+func publishSystemEvent(publishChan chan Message, topicPrefix string, event systemEvent) {
+	payload, errMarshal := json.Marshal(event)
+	if errMarshal != nil {
+		log.Error("Could not marshal system event: %s", errMarshal)
+		return
+	}
+
+	publishChan <- Message{
+		Topic:   fmt.Sprintf("%s/system/event", topicPrefix),
+		Payload: payload,
+		Class:   ClassEvent,
+	}
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"testing"
+	"time"
+)
+
+type uptimeClient struct {
+	fritzbox.TR064Client
+	uptimes []string
+	resets  int
+}
+
+func (c *uptimeClient) Call(string, string, map[string]string) (map[string]string, error) {
+	uptime := c.uptimes[0]
+	if len(c.uptimes) > 1 {
+		c.uptimes = c.uptimes[1:]
+	}
+	return map[string]string{"NewUpTime": uptime, "NewSoftwareVersion": "8.00"}, nil
+}
+
+func (c *uptimeClient) Reset() {
+	c.resets++
+}
+
+func Test_StartSystem(t *testing.T) {
+	client := &uptimeClient{uptimes: []string{"100", "200", "5"}}
+	systemChan := make(chan byte, 1)
+	publishChan := make(chan Message, 100)
+	reloginChan := make(chan byte, 1)
+
+	done := make(chan error)
+	go func() {
+		done <- StartSystem(systemChan, client, publishChan, "fritze", time.Millisecond, reloginChan)
+	}()
+
+	select {
+	case <-reloginChan:
+	case <-time.After(time.Second):
+		t.Fatal("no relogin after the uptime went down")
+	}
+	systemChan <- 1
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	reboots := 0
+	for len(publishChan) > 0 {
+		if message := <-publishChan; message.Topic == "fritze/system/event" {
+			reboots++
+		}
+	}
+	if reboots != 1 || client.resets != 1 {
+		t.Errorf("expected a single reboot, got %d events and %d resets", reboots, client.resets)
+	}
+}
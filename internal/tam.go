@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"github.com/webishdev/fritze-mqtt/log"
+	"strconv"
+	"time"
+)
+
+func StartTAM(tamChan chan byte, tc fritzbox.TR064Client, publishChan chan Message, topicPrefix string, interval time.Duration) error {
+	var seen map[int]map[int]bool
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		tams, indexToMessages, errTAMs := fritzbox.GetTAMs(tc)
+		if errTAMs != nil {
+			log.Error("Could not read answering machines: %s", errTAMs)
+		} else {
+			for _, tam := range tams {
+				publishTAM(publishChan, topicPrefix, tam)
+			}
+			seen = newTAMMessages(seen, indexToMessages, func(message fritzbox.TAMMessage) {
+				log.Info("New message on answering machine %d from %s", message.TAM, message.Number)
+				publishTAMMessage(publishChan, topicPrefix, message)
+			})
+		}
+
+		select {
+		case <-tamChan:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func TAMCommands(tc fritzbox.TR064Client, publishChan chan Message, topicPrefix string) []Command {
+	return []Command{
+		{
+			Topic: fmt.Sprintf("%s/tam/+/set", topicPrefix),
+			Handler: inBackground(func(topic string, payload []byte) {
+				index, errIndex := strconv.Atoi(topicLevel(topic, -2))
+				if errIndex != nil {
+					log.Warn("Invalid answering machine in %s", topic)
+					return
+				}
+
+				enabled, errPayload := parseOnOff(payload)
+				if errPayload != nil {
+					log.Warn("Invalid payload %q for %s: %s", payload, topic, errPayload)
+					return
+				}
+
+				if errSet := fritzbox.SetTAMEnabled(tc, index, enabled); errSet != nil {
+					log.Error("Could not switch answering machine %d: %s", index, errSet)
+					return
+				}
+				log.Info("Switched answering machine %d to enabled=%t", index, enabled)
+
+				tams, _, errTAMs := fritzbox.GetTAMs(tc)
+				if errTAMs != nil {
+					log.Error("Could not read answering machines: %s", errTAMs)
+					return
+				}
+				for _, tam := range tams {
+					publishTAM(publishChan, topicPrefix, tam)
+				}
+			}),
+		},
+	}
+}
+
+// Messages already present when an answering machine shows up are only remembered, later ones are reported once
+func newTAMMessages(seen map[int]map[int]bool, indexToMessages map[int][]fritzbox.TAMMessage, report func(message fritzbox.TAMMessage)) map[int]map[int]bool {
+	current := map[int]map[int]bool{}
+
+	for index, messages := range indexToMessages {
+		_, known := seen[index]
+		initial := !known
+		current[index] = map[int]bool{}
+		for _, message := range messages {
+			current[index][message.Index] = true
+			if initial || !message.New || seen[index][message.Index] {
+				continue
+			}
+			report(message)
+		}
+	}
+
+	return current
+}
+
+func publishTAM(publishChan chan Message, topicPrefix string, tam fritzbox.TAM) {
+	payload, errMarshal := json.Marshal(tam)
+	if errMarshal != nil {
+		log.Error("Could not marshal answering machine %d: %s", tam.Index, errMarshal)
+		return
+	}
+
+	publishChan <- Message{
+		Topic:    fmt.Sprintf("%s/tam/%d/state", topicPrefix, tam.Index),
+		Payload:  payload,
+		Retained: true,
+		Class:    ClassState,
+	}
+}
+
+func publishTAMMessage(publishChan chan Message, topicPrefix string, message fritzbox.TAMMessage) {
+	payload, errMarshal := json.Marshal(message)
+	if errMarshal != nil {
+		log.Error("Could not marshal answering machine message: %s", errMarshal)
+		return
+	}
+
+	publishChan <- Message{
+		Topic:   fmt.Sprintf("%s/tam/%d/message", topicPrefix, message.TAM),
+		Payload: payload,
+		Class:   ClassEvent,
+	}
+}
@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"testing"
+)
+
+func Test_newTAMMessages(t *testing.T) {
+	var reported []fritzbox.TAMMessage
+	report := func(message fritzbox.TAMMessage) {
+		reported = append(reported, message)
+	}
+
+	seen := newTAMMessages(nil, map[int][]fritzbox.TAMMessage{
+		0: {{Index: 1, TAM: 0, New: true}},
+	}, report)
+	if len(reported) != 0 {
+		t.Fatalf("messages of the first poll must not be reported, got %v", reported)
+	}
+
+	seen = newTAMMessages(seen, map[int][]fritzbox.TAMMessage{
+		0: {{Index: 1, TAM: 0, New: true}, {Index: 2, TAM: 0, New: true}, {Index: 3, TAM: 0}},
+		// Shown on the display only now, its messages are not new to anybody
+		1: {{Index: 1, TAM: 1, New: true}},
+	}, report)
+	if len(reported) != 1 || reported[0].TAM != 0 || reported[0].Index != 2 {
+		t.Fatalf("expected only the new message on answering machine 0, got %v", reported)
+	}
+
+	newTAMMessages(seen, map[int][]fritzbox.TAMMessage{
+		0: {{Index: 2, TAM: 0, New: true}},
+		1: {{Index: 1, TAM: 1, New: true}, {Index: 2, TAM: 1, New: true}},
+	}, report)
+	if len(reported) != 2 || reported[1].TAM != 1 || reported[1].Index != 2 {
+		t.Errorf("expected the new message on answering machine 1, got %v", reported)
+	}
+}
@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const (
+	PayloadRaw      = "raw"
+	PayloadJSON     = "json"
+	PayloadTemplate = "template"
+
+	DefaultTopicTemplate = "{{.Prefix}}/{{.ID}}/{{.Capability}}"
+)
+
+// Renders topic and payload of device values and events
+type DeviceTemplate struct {
+	topic       *template.Template
+	payloadMode string
+	payload     *template.Template
+}
+
+type deviceTemplateData struct {
+	Prefix     string
+	AIN        string
+	ID         string
+	Name       string
+	Product    string
+	Type       string
+	Capability string
+	Value      string
+}
+
+var defaultDeviceTemplate = &DeviceTemplate{
+	topic:       template.Must(template.New("topic").Parse(DefaultTopicTemplate)),
+	payloadMode: PayloadRaw,
+}
+
+func NewDeviceTemplate(topic string, payloadMode string, payload string) (*DeviceTemplate, error) {
+	if topic == "" {
+		topic = DefaultTopicTemplate
+	}
+	topicTemplate, err := template.New("topic").Option("missingkey=error").Parse(topic)
+	if err != nil {
+		return nil, fmt.Errorf("invalid topic template: %w", err)
+	}
+
+	t := &DeviceTemplate{topic: topicTemplate, payloadMode: payloadMode}
+	switch payloadMode {
+	case PayloadRaw, PayloadJSON:
+		if payload != "" {
+			return nil, fmt.Errorf("a payload template requires the %s payload mode", PayloadTemplate)
+		}
+	case PayloadTemplate:
+		if payload == "" {
+			return nil, fmt.Errorf("the %s payload mode requires a payload template", PayloadTemplate)
+		}
+		t.payload, err = template.New("payload").Option("missingkey=error").Parse(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload template: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown payload mode %s", payloadMode)
+	}
+
+	// Unknown fields only show up when executing, fail at startup instead of on the first publish.
+	// Values of different capabilities or devices sharing a topic would overwrite each other.
+	samples := []fritzbox.Device{
+		{Identifier: "11657 0240192", Name: "Sample", ProductName: "FRITZ!DECT 200", StateValue: 1},
+		{Identifier: "11657 0240193", Name: "Other sample", ProductName: "FRITZ!DECT 200", StateValue: 1},
+	}
+	rendered := map[string]bool{}
+	for _, sample := range samples {
+		for _, capability := range []string{"state", "power"} {
+			topic, _, errRender := t.render("fritze", sample, capability, "1")
+			if errRender != nil {
+				return nil, errRender
+			}
+			if rendered[topic] {
+				return nil, fmt.Errorf("topic template renders %s for different capabilities or devices", topic)
+			}
+			rendered[topic] = true
+		}
+	}
+
+	return t, nil
+}
+
+func (t *DeviceTemplate) render(topicPrefix string, device fritzbox.Device, capability string, value string) (string, []byte, error) {
+	data := newDeviceTemplateData(topicPrefix, device, capability, value)
+
+	topic, err := t.renderTopic(data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch t.payloadMode {
+	case PayloadJSON:
+		payload, errMarshal := json.Marshal(map[string]any{
+			"ain":        data.AIN,
+			"name":       data.Name,
+			"product":    data.Product,
+			"type":       data.Type,
+			"capability": data.Capability,
+			"value":      jsonValue(value),
+		})
+		return topic, payload, errMarshal
+	case PayloadTemplate:
+		var payload bytes.Buffer
+		if errExecute := t.payload.Execute(&payload, data); errExecute != nil {
+			return "", nil, errExecute
+		}
+		return topic, payload.Bytes(), nil
+	default:
+		return topic, []byte(value), nil
+	}
+}
+
+func (t *DeviceTemplate) renderTopic(data deviceTemplateData) (string, error) {
+	var topic bytes.Buffer
+	if err := t.topic.Execute(&topic, data); err != nil {
+		return "", err
+	}
+	if topic.Len() == 0 || strings.ContainsAny(topic.String(), "+#") {
+		return "", fmt.Errorf("invalid topic %q", topic.String())
+	}
+	return topic.String(), nil
+}
+
+// The topic a capability of a device is published on, independent of its value
+func (t *DeviceTemplate) stateTopic(topicPrefix string, device fritzbox.Device, capability string) (string, error) {
+	return t.renderTopic(newDeviceTemplateData(topicPrefix, device, capability, ""))
+}
+
+func newDeviceTemplateData(topicPrefix string, device fritzbox.Device, capability string, value string) deviceTemplateData {
+	return deviceTemplateData{
+		Prefix:     topicPrefix,
+		AIN:        device.Identifier,
+		ID:         deviceTopicID(device.Identifier),
+		Name:       device.Name,
+		Product:    device.ProductName,
+		Type:       deviceType(device),
+		Capability: capability,
+		Value:      value,
+	}
+}
+
+func deviceType(device fritzbox.Device) string {
+	switch homeAssistantComponent(device) {
+	case "cover":
+		return "blind"
+	case "light":
+		return "light"
+	case "climate":
+		return "thermostat"
+	case "binary_sensor":
+		return "detector"
+	case "device_automation":
+		return "button"
+	case "switch":
+		return "switch"
+	default:
+		return "sensor"
+	}
+}
+
+// Numbers stay numbers in JSON payloads
+func jsonValue(value string) any {
+	if _, err := strconv.ParseFloat(value, 64); err == nil && json.Valid([]byte(value)) {
+		return json.Number(value)
+	}
+	return value
+}
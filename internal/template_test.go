@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"testing"
+)
+
+func Test_deviceTemplateRender(t *testing.T) {
+	device := fritzbox.Device{
+		ProductName: "FRITZ!DECT 200",
+		Identifier:  "11657 0240192",
+		Name:        "Washing machine",
+		StateValue:  1,
+		Functions:   []fritzbox.DeviceFunction{fritzbox.AVMOutletSwitch},
+	}
+
+	tests := []struct {
+		name            string
+		topic           string
+		payloadMode     string
+		payload         string
+		value           string
+		expectedTopic   string
+		expectedPayload string
+	}{
+		{"default", "", PayloadRaw, "", "1", "fritze/116570240192/power", "1"},
+		{"json", "home/{{.Type}}/{{.Name}}/{{.Capability}}", PayloadJSON, "", "1.52", "home/switch/Washing machine/power", `{"ain":"11657 0240192","capability":"power","name":"Washing machine","product":"FRITZ!DECT 200","type":"switch","value":1.52}`},
+		{"template", "{{.Prefix}}/{{.AIN}}/{{.Capability}}", PayloadTemplate, `{{.Capability}}={{.Value}}`, "1.52", "fritze/11657 0240192/power", "power=1.52"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deviceTemplate, err := NewDeviceTemplate(tt.topic, tt.payloadMode, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			topic, payload, err := deviceTemplate.render("fritze", device, "power", tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if topic != tt.expectedTopic || string(payload) != tt.expectedPayload {
+				t.Errorf("render() = %s %s, expected %s %s", topic, payload, tt.expectedTopic, tt.expectedPayload)
+			}
+		})
+	}
+}
+
+func Test_newDeviceTemplateInvalid(t *testing.T) {
+	if _, err := NewDeviceTemplate("{{.Unknown}}", PayloadRaw, ""); err == nil {
+		t.Error("unknown field accepted")
+	}
+	if _, err := NewDeviceTemplate("fritze/+/{{.Capability}}", PayloadRaw, ""); err == nil {
+		t.Error("wildcard in topic accepted")
+	}
+	if _, err := NewDeviceTemplate("{{.Prefix}}/{{.AIN}}", PayloadRaw, ""); err == nil {
+		t.Error("topic shared by capabilities accepted")
+	}
+	if _, err := NewDeviceTemplate("{{.Prefix}}/{{.Type}}/{{.Capability}}", PayloadRaw, ""); err == nil {
+		t.Error("topic shared by devices accepted")
+	}
+	if _, err := NewDeviceTemplate("", PayloadTemplate, ""); err == nil {
+		t.Error("template mode without template accepted")
+	}
+	if _, err := NewDeviceTemplate("", "xml", ""); err == nil {
+		t.Error("unknown payload mode accepted")
+	}
+}
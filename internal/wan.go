@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"github.com/webishdev/fritze-mqtt/log"
+	"time"
+)
+
+func StartWAN(wanChan chan byte, tc fritzbox.TR064Client, publishChan chan Message, topicPrefix string, interval time.Duration) error {
+	lastConnectionStatus := ""
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status, errStatus := fritzbox.GetWANStatus(tc)
+		if errStatus != nil {
+			log.Error("Could not read WAN status: %s", errStatus)
+		} else {
+			if status.ConnectionStatus != lastConnectionStatus {
+				log.Info("WAN connection is %s (%s)", status.ConnectionStatus, status.AccessType)
+				lastConnectionStatus = status.ConnectionStatus
+			}
+			publishWANStatus(publishChan, topicPrefix, status)
+		}
+
+		select {
+		case <-wanChan:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func publishWANStatus(publishChan chan Message, topicPrefix string, status fritzbox.WANStatus) {
+	payload, errMarshal := json.Marshal(status)
+	if errMarshal != nil {
+		log.Error("Could not marshal WAN status: %s", errMarshal)
+		return
+	}
+
+	publishChan <- Message{
+		Topic:    fmt.Sprintf("%s/wan/state", topicPrefix),
+		Payload:  payload,
+		Retained: true,
+		Class:    ClassState,
+	}
+
+	publishChan <- Message{
+		Topic:    fmt.Sprintf("%s/wan/connection", topicPrefix),
+		Payload:  []byte(status.ConnectionStatus),
+		Retained: true,
+		Class:    ClassState,
+	}
+}
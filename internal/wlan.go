@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"github.com/webishdev/fritze-mqtt/log"
+	"time"
+)
+
+func StartWLAN(wlanChan chan byte, tc fritzbox.TR064Client, publishChan chan Message, topicPrefix string, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		publishWLANs(tc, publishChan, topicPrefix)
+
+		select {
+		case <-wlanChan:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func WLANCommands(tc fritzbox.TR064Client, publishChan chan Message, topicPrefix string) []Command {
+	return []Command{
+		{
+			Topic: fmt.Sprintf("%s/wlan/+/set", topicPrefix),
+			Handler: inBackground(func(topic string, payload []byte) {
+				name := topicLevel(topic, -2)
+				enabled, errPayload := parseOnOff(payload)
+				if errPayload != nil {
+					log.Warn("Invalid payload %q for %s: %s", payload, topic, errPayload)
+					return
+				}
+
+				wlans, errWLANs := fritzbox.GetWLANs(tc)
+				if errWLANs != nil {
+					log.Error("Could not read WLAN configuration: %s", errWLANs)
+					return
+				}
+
+				for _, wlan := range wlans {
+					if wlan.Name != name {
+						continue
+					}
+					if errSet := fritzbox.SetWLANEnabled(tc, wlan.Index, enabled); errSet != nil {
+						log.Error("Could not switch WLAN %s: %s", name, errSet)
+						return
+					}
+					log.Info("Switched WLAN %s (%s) to enabled=%t", name, wlan.SSID, enabled)
+					publishWLANs(tc, publishChan, topicPrefix)
+					return
+				}
+
+				log.Warn("Unknown WLAN %s", name)
+			}),
+		},
+	}
+}
+
+func publishWLANs(tc fritzbox.TR064Client, publishChan chan Message, topicPrefix string) {
+	wlans, errWLANs := fritzbox.GetWLANs(tc)
+	if errWLANs != nil {
+		log.Error("Could not read WLAN configuration: %s", errWLANs)
+		return
+	}
+
+	for _, wlan := range wlans {
+		payload, errMarshal := json.Marshal(wlan)
+		if errMarshal != nil {
+			log.Error("Could not marshal WLAN %s: %s", wlan.Name, errMarshal)
+			continue
+		}
+
+		publishChan <- Message{
+			Topic:    fmt.Sprintf("%s/wlan/%s/state", topicPrefix, wlan.Name),
+			Payload:  payload,
+			Retained: true,
+			Class:    ClassState,
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/webishdev/fritze-mqtt/fritzbox"
+	"github.com/webishdev/fritze-mqtt/log"
+	"strings"
+)
+
+type wakeResult struct {
+	Target  string `json:"target"`
+	MAC     string `json:"mac,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+func WakeHost(tc fritzbox.TR064Client, target string) error {
+	result := wake(tc, target)
+	if !result.Success {
+		return fmt.Errorf("could not wake %s: %s", target, result.Error)
+	}
+
+	fmt.Printf("Sent wake on LAN to %s (%s)\n", result.MAC, result.Name)
+
+	return nil
+}
+
+func WakeOnLANCommands(tc fritzbox.TR064Client, publishChan chan Message, topicPrefix string) []Command {
+	return []Command{
+		{
+			Topic: fmt.Sprintf("%s/wol/wake", topicPrefix),
+			Handler: inBackground(func(topic string, payload []byte) {
+				result := wake(tc, string(payload))
+
+				response, errMarshal := json.Marshal(result)
+				if errMarshal != nil {
+					log.Error("Could not marshal wake on LAN result: %s", errMarshal)
+					return
+				}
+
+				publishChan <- Message{
+					Topic:   fmt.Sprintf("%s/wol/response", topicPrefix),
+					Payload: response,
+					Class:   ClassEvent,
+				}
+			}),
+		},
+	}
+}
+
+func wake(tc fritzbox.TR064Client, target string) wakeResult {
+	result := wakeResult{
+		Target: strings.TrimSpace(target),
+	}
+
+	host, errResolve := fritzbox.ResolveHost(tc, result.Target)
+	if errResolve != nil {
+		result.Error = errResolve.Error()
+		log.Warn("Could not resolve %s for wake on LAN: %s", result.Target, errResolve)
+		return result
+	}
+	result.MAC = host.MACAddress
+	result.Name = host.HostName
+
+	if errWake := fritzbox.WakeOnLAN(tc, host.MACAddress); errWake != nil {
+		result.Error = errWake.Error()
+		log.Error("Wake on LAN for %s failed: %s", host.MACAddress, errWake)
+		return result
+	}
+
+	result.Success = true
+	log.Info("Sent wake on LAN to %s (%s)", host.MACAddress, host.HostName)
+
+	return result
+}
@@ -0,0 +1,29 @@
+package internal
+
+import "testing"
+
+func Test_wake(t *testing.T) {
+	tests := []struct {
+		target  string
+		mac     string
+		name    string
+		success bool
+	}{
+		{"laptop\n", "AA:BB:CC:DD:EE:02", "laptop", true},
+		{"aa-bb-cc-dd-ee-01", "AA:BB:CC:DD:EE:01", "phone", true},
+		// Unknown MACs are woken anyway, the FRITZ!Box need not know the host
+		{"AA:BB:CC:DD:EE:03", "AA:BB:CC:DD:EE:03", "", true},
+		{"tablet", "", "", false},
+	}
+	for _, tt := range tests {
+		client := newHostsClient()
+		result := wake(client, tt.target)
+		if result.Success != tt.success || result.MAC != tt.mac || result.Name != tt.name {
+			t.Errorf("wake(%q) = %+v", tt.target, result)
+			continue
+		}
+		if tt.success && (len(client.woken) != 1 || client.woken[0] != tt.mac) {
+			t.Errorf("wake(%q) woke %v", tt.target, client.woken)
+		}
+	}
+}